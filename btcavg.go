@@ -11,11 +11,14 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/gocraft/health"
 )
 
 const (
-	btcavgFiatEndpoint   = "https://apiv2.bitcoinaverage.com/indices/global/ticker/all?crypto=BTC"
-	btcavgCryptoEndpoint = "https://apiv2.bitcoinaverage.com/indices/crypto/ticker/all"
+	btcavgDefaultBaseURL = "https://apiv2.bitcoinaverage.com"
+	btcavgFiatPath       = "/indices/global/ticker/all?crypto=BTC"
+	btcavgCryptoPath     = "/indices/crypto/ticker/all"
 )
 
 type btcavgFetcher struct {
@@ -23,34 +26,48 @@ type btcavgFetcher struct {
 	privkey string
 }
 
-func NewBTCAVGFetcher(pubkey string, privkey string) fetchFn {
-	return func() (exchangeRates, error) {
-		output := exchangeRates{}
-		errCh := make(chan error, 2)
+// BTCAVGKey is a BitcoinAverage API key pair
+type BTCAVGKey struct {
+	Pubkey  string `json:"pubkey"`
+	Privkey string `json:"privkey"`
+}
+
+// NewBTCAVGFetcher creates a BitcoinAverage fetcher. When several keys are
+// given the next is used when one is rate limited or rejected.
+func NewBTCAVGFetcher(baseURL string, keys ...BTCAVGKey) fetchFn {
+	if len(keys) == 0 {
+		keys = []BTCAVGKey{{}}
+	}
+	return func(job *health.Job, client *http.Client) (exchangeRates, error) {
+		var (
+			fiatRates   = exchangeRates{}
+			cryptoRates = exchangeRates{}
+			errCh       = make(chan error, 2)
+		)
 
 		// Request both endpoints and save their responses
 		wg := sync.WaitGroup{}
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			rates, err := fetchBTCAVGResource(btcavgFiatEndpoint, pubkey, privkey)
+			rates, err := fetchBTCAVGResourceWithKeys(job, client, baseURL+btcavgFiatPath, keys)
 			if err != nil {
 				errCh <- err
 				return
 			}
 
-			formatBTCAVGFiatOutput(output, rates)
+			formatBTCAVGFiatOutput(fiatRates, rates)
 		}()
 
 		go func() {
 			defer wg.Done()
-			rates, err := fetchBTCAVGResource(btcavgCryptoEndpoint, pubkey, privkey)
+			rates, err := fetchBTCAVGResourceWithKeys(job, client, baseURL+btcavgCryptoPath, keys)
 			if err != nil {
 				errCh <- err
 				return
 			}
 
-			err = formatBTCAVGCryptoOutput(output, rates)
+			err = formatBTCAVGCryptoOutput(cryptoRates, rates)
 			if err != nil {
 				errCh <- err
 				return
@@ -63,12 +80,24 @@ func NewBTCAVGFetcher(pubkey string, privkey string) fetchFn {
 			return nil, err
 		}
 
-		return output, nil
+		return mergeRates([]exchangeRates{fiatRates, cryptoRates}), nil
 	}
 }
 
+// fetchBTCAVGResourceWithKeys gets the response for a given BitcoinAverage
+// endpoint, rotating through the keys
+func fetchBTCAVGResourceWithKeys(job *health.Job, client *http.Client, url string, keys []BTCAVGKey) (exchangeRates, error) {
+	var rates exchangeRates
+	err := rotateKeys(job, "btcavg", len(keys), func(key int) (int, error) {
+		var err error
+		rates, err = fetchBTCAVGResource(client, url, keys[key].Pubkey, keys[key].Privkey)
+		return 0, err
+	})
+	return rates, err
+}
+
 // fetchBTCAVGResource gets the response for a given BitcoinAverage endpoint
-func fetchBTCAVGResource(url string, pubkey string, privkey string) (exchangeRates, error) {
+func fetchBTCAVGResource(client *http.Client, url string, pubkey string, privkey string) (exchangeRates, error) {
 	// Create signed request
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -81,7 +110,7 @@ func fetchBTCAVGResource(url string, pubkey string, privkey string) (exchangeRat
 	}
 
 	// Send the requests
-	resp, err := httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -94,8 +123,8 @@ func fetchBTCAVGResource(url string, pubkey string, privkey string) (exchangeRat
 	}
 
 	// Return an error if no success, otherwise deserialize and return our body
-	if resp.StatusCode != 200 {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		return nil, errUnexpectedStatus{"btcavg", resp.StatusCode}
 	}
 
 	rates := make(exchangeRates)
@@ -126,6 +155,10 @@ func formatBTCAVGCryptoOutput(outgoing exchangeRates, incoming exchangeRates) er
 		}
 		symbol := CanonicalizeSymbol(trimmedSymbol)
 
+		if isBannedCryptoSymbol(symbol) {
+			continue
+		}
+
 		if !IsCorrectIDForSymbol(symbol, entry.ID) {
 			continue
 		}
@@ -134,11 +167,13 @@ func formatBTCAVGCryptoOutput(outgoing exchangeRates, incoming exchangeRates) er
 			continue
 		}
 
-		ask, err := invertAndFormatPrice(entry.Ask)
+		// Selling BTC for the coin at its ask is buying the coin at the inverse,
+		// so inverting swaps the ask and the bid
+		ask, err := invertAndFormatPrice(entry.Bid)
 		if err != nil {
 			return err
 		}
-		bid, err := invertAndFormatPrice(entry.Bid)
+		bid, err := invertAndFormatPrice(entry.Ask)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,187 @@
+package ticker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+// CacheConfig configures the provider response cache
+type CacheConfig struct {
+	// Path is a local directory or s3://bucket/prefix URL to store responses
+	// in. Empty disables the cache.
+	Path string `json:"path"`
+
+	// TTL is how long responses are reused for, by provider name, e.g.
+	// {"cmc": "5m"}. Providers without a TTL aren't cached.
+	TTL map[string]string `json:"ttl,omitempty"`
+
+	// Bypass ignores cached responses. Fresh responses are still stored.
+	Bypass bool `json:"bypass,omitempty"`
+}
+
+// validate checks that the cache is well formed
+func (c CacheConfig) validate() error {
+	for name, ttl := range c.TTL {
+		if !isProviderName(name) {
+			return fmt.Errorf("cache ttl set for unknown provider %q", name)
+		}
+		if duration, err := time.ParseDuration(ttl); err != nil || duration <= 0 {
+			return fmt.Errorf("cache ttl for %s must be a positive duration, got %q", name, ttl)
+		}
+	}
+	return nil
+}
+
+// ttl returns how long responses from the provider are cached for. It is 0
+// when the provider isn't cached.
+func (c CacheConfig) ttl(provider string) time.Duration {
+	if c.Path == "" {
+		return 0
+	}
+	ttl, _ := time.ParseDuration(c.TTL[provider])
+	return ttl
+}
+
+// cachedResponse is a provider response stored in the cache
+type cachedResponse struct {
+	URL      string      `json:"url"`
+	StoredAt time.Time   `json:"stored_at"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+}
+
+// newProviderClient returns the client to make the provider's requests with.
+// Requests go through the response cache when the provider has a TTL.
+func newProviderClient(job *health.Job, conf Config, provider string) *http.Client {
+	ttl := conf.Cache.ttl(provider)
+	if ttl == 0 {
+		return httpClient
+	}
+
+	return &http.Client{
+		Timeout: httpClient.Timeout,
+		Transport: &cachingTransport{
+			job:      job,
+			conf:     conf,
+			provider: provider,
+			ttl:      ttl,
+			base:     httpClient.Transport,
+		},
+	}
+}
+
+// cachingTransport is an http.RoundTripper that answers a provider's GET
+// requests from the response cache while the cached response is fresh, and
+// stores successful responses
+type cachingTransport struct {
+	job      *health.Job
+	conf     Config
+	provider string
+	ttl      time.Duration
+	base     http.RoundTripper
+}
+
+// RoundTrip returns a fresh cached response or performs the request
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.baseTransport().RoundTrip(req)
+	}
+
+	kvs := health.Kvs{"provider": t.provider}
+	location := t.location(req)
+	if !t.conf.Cache.Bypass {
+		cached, err := t.load(location)
+		if err != nil {
+			t.job.EventErrKv("cache.read", err, kvs)
+		} else if cached != nil && time.Since(cached.StoredAt) < t.ttl {
+			t.job.EventKv("cache.hit", kvs)
+			return cached.response(req), nil
+		}
+	}
+	t.job.EventKv("cache.miss", kvs)
+
+	resp, err := t.baseTransport().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	data, err := json.Marshal(cachedResponse{
+		URL:      scrubURL(req.URL),
+		StoredAt: time.Now().UTC(),
+		Status:   resp.StatusCode,
+		Header:   http.Header{"Content-Type": resp.Header["Content-Type"]},
+		Body:     body,
+	})
+	if err == nil {
+		err = writeResource(t.conf, location, data)
+	}
+	if err != nil {
+		// The response is still usable without being cached
+		t.job.EventErrKv("cache.write", err, kvs)
+	}
+
+	return resp, nil
+}
+
+func (t *cachingTransport) baseTransport() http.RoundTripper {
+	if t.base != nil {
+		return t.base
+	}
+	return http.DefaultTransport
+}
+
+// location returns where the response to the request is cached. Requests are
+// keyed by provider, endpoint and query; credentials aren't part of the key.
+func (t *cachingTransport) location(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + scrubURL(req.URL)))
+	return strings.TrimSuffix(t.conf.Cache.Path, "/") + "/" + t.provider + "/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// load reads a cached response. It returns nil if nothing is cached.
+func (t *cachingTransport) load(location string) (*cachedResponse, error) {
+	data, err := readResource(t.conf, location)
+	if isResourceNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &cachedResponse{}
+	err = json.Unmarshal(data, cached)
+	if err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(c.Status),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,94 @@
+package ticker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestResponseCache(t *testing.T) {
+	provider := fakeprovider.New(1, 3)
+	server := httptest.NewServer(provider)
+	defer server.Close()
+	err := provider.SetScenarios(fakeprovider.ScenarioRandomWalk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cachePath, err := ioutil.TempDir("", "ticker_proxy_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachePath)
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.CMCBaseURL = server.URL
+	conf.Cache = CacheConfig{Path: cachePath, TTL: map[string]string{"cmc": "1h"}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func() (exchangeRates, []string) {
+		sink := &testEventSink{}
+		stream := health.NewStream()
+		stream.AddSink(sink)
+		writer := NewMemoryWriter()
+		err := Fetch(stream, conf, writer.Write)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cacheEvents := []string{}
+		for _, event := range sink.events {
+			if event == "cache.hit" || event == "cache.miss" {
+				cacheEvents = append(cacheEvents, event)
+			}
+		}
+
+		rates := exchangeRates{}
+		err = json.Unmarshal(writer.artifacts["api"].Data, &rates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rates, cacheEvents
+	}
+
+	// Only the CMC pages are cached
+	first, events := fetch()
+	if !reflect.DeepEqual(events, []string{"cache.miss", "cache.miss", "cache.miss"}) {
+		t.Fatal("Expected cache misses, got:", events)
+	}
+
+	second, events := fetch()
+	if !reflect.DeepEqual(events, []string{"cache.hit", "cache.hit", "cache.hit"}) {
+		t.Fatal("Expected cache hits, got:", events)
+	}
+	if second["FAKE1"].Last != first["FAKE1"].Last {
+		t.Fatal("Expected cached CMC rates to be reused")
+	}
+	if second["USD"].Last == first["USD"].Last {
+		t.Fatal("Expected uncached BTCAVG rates to be fetched")
+	}
+
+	conf.Cache.Bypass = true
+	bypassed, events := fetch()
+	if !reflect.DeepEqual(events, []string{"cache.miss", "cache.miss", "cache.miss"}) {
+		t.Fatal("Expected the cache to be bypassed, got:", events)
+	}
+	if bypassed["FAKE1"].Last == first["FAKE1"].Last {
+		t.Fatal("Expected fresh CMC rates when bypassing the cache")
+	}
+
+	conf.Cache.TTL = map[string]string{"unknown": "1m"}
+	if _, ok := conf.Validate().(errInvalidConfig); !ok {
+		t.Fatal("Expected TTL for unknown provider to be invalid")
+	}
+}
@@ -7,36 +7,66 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+
+	"github.com/gocraft/health"
 )
 
 const (
-	cmcQueryEndpointTemplate = "https://%s-api.coinmarketcap.com/v1/cryptocurrency/listings/latest"
-	cmcQueryFirstID          = 1
+	cmcBaseURLTemplate = "https://%s-api.coinmarketcap.com"
+	cmcListingsPath    = "/v1/cryptocurrency/listings/latest"
+	cmcQuotesPath      = "/v1/cryptocurrency/quotes/latest"
+	cmcQueryFirstID    = 1
+
+	// cmcDefaultQueryLimit is the page size of listings requests
+	cmcDefaultQueryLimit = 5000
 )
 
-var cmcQueryLimit = 5000
+// CMC fetch modes
+const (
+	// CMCModeListings pages through every listed coin
+	CMCModeListings = "listings"
+
+	// CMCModeQuotes fetches the quotes of a fixed set of coin IDs
+	CMCModeQuotes = "quotes"
+)
 
-var bannedCryptoSymbols = map[string]struct{}{
-	// "CRC":  struct{}{},
-	// "HCA":  struct{}{},
-	// "EMS":  struct{}{},
-	// "CDAI": struct{}{},
-	// "LLC":  struct{}{},
-	// "USD-N": struct{}{},
-	"USD": {},
-}
+var cmcQueryLimit = cmcDefaultQueryLimit
+
+// cmcQuotesBatchSize is the most IDs requested from the quotes endpoint at once
+var cmcQuotesBatchSize = 100
 
 type cmcResponse struct {
-	Data []struct {
-		ID     int64  `json:"id"`
-		Symbol string `json:"symbol"`
-		Name   string `json:"name"`
-		Quote  struct {
-			BTC struct {
-				Price JSONNumber `json:"price"`
-			} `json:"BTC"`
-		} `json:"quote"`
-	} `json:"data"`
+	Data []cmcEntry `json:"data"`
+}
+
+// cmcStatus is the status every CMC response carries
+type cmcStatus struct {
+	Status struct {
+		CreditCount int `json:"credit_count"`
+	} `json:"status"`
+}
+
+// cmcQuotesResponse is the quotes endpoint's response, keyed by coin ID
+type cmcQuotesResponse struct {
+	Data map[string]cmcEntry `json:"data"`
+}
+
+type cmcEntry struct {
+	ID     int64  `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+	Rank   int    `json:"cmc_rank"`
+	Quote  struct {
+		BTC struct {
+			Price            JSONNumber `json:"price"`
+			Volume24h        *float64   `json:"volume_24h"`
+			MarketCap        *float64   `json:"market_cap"`
+			PercentChange1h  *float64   `json:"percent_change_1h"`
+			PercentChange24h *float64   `json:"percent_change_24h"`
+			PercentChange7d  *float64   `json:"percent_change_7d"`
+		} `json:"BTC"`
+	} `json:"quote"`
 }
 
 // JSONNumber stores price value. Handles null values
@@ -71,18 +101,25 @@ func (n JSONNumber) Float64() (float64, error) {
 	return strconv.ParseFloat(string(n.Value), 64)
 }
 
-func NewCMCFetcher(env string, apiKey string) fetchFn {
-	return func() (exchangeRates, error) {
+// NewCMCFetcher creates a CMC fetcher that pages through every listing. When
+// several API keys are given the next is used when one is rate limited or
+// rejected.
+func NewCMCFetcher(baseURL string, apiKeys ...string) fetchFn {
+	if len(apiKeys) == 0 {
+		apiKeys = []string{""}
+	}
+	return func(job *health.Job, client *http.Client) (exchangeRates, error) {
 		var (
-			err    error = nil
-			resp         = &cmcResponse{}
-			output       = exchangeRates{}
+			err     error = nil
+			resp          = &cmcResponse{}
+			output        = exchangeRates{}
+			seenIDs       = symbolIDTracker{}
 		)
 
 		// Start at the first ID and keep grabbing pages until we get less than we
 		// requested or there is an error
 		for i := 0; i < 100; i++ {
-			resp, err = fetchCMCResource(env, apiKey, cmcQueryFirstID+(i*cmcQueryLimit), cmcQueryLimit, output)
+			resp, err = fetchCMCResource(job, client, baseURL, apiKeys, cmcQueryFirstID+(i*cmcQueryLimit), cmcQueryLimit, output, seenIDs)
 			if err != nil {
 				return nil, err
 			}
@@ -93,78 +130,186 @@ func NewCMCFetcher(env string, apiKey string) fetchFn {
 			}
 		}
 
+		seenIDs.warnUnpinnedDuplicates(job, "cmc")
+
+		return output, nil
+	}
+}
+
+// NewCMCQuotesFetcher creates a CMC fetcher that only requests the quotes of
+// the given coin IDs, in batches, which costs far fewer credits than paging
+// through every listing. The IDs pinned by the current symbol policy are used
+// when none are given. API keys are rotated like NewCMCFetcher's.
+func NewCMCQuotesFetcher(baseURL string, ids []int64, apiKeys ...string) fetchFn {
+	if len(apiKeys) == 0 {
+		apiKeys = []string{""}
+	}
+	return func(job *health.Job, client *http.Client) (exchangeRates, error) {
+		output := exchangeRates{}
+		seenIDs := symbolIDTracker{}
+
+		// The pinned IDs are looked up every run so policy changes are picked up
+		quoteIDs := ids
+		if len(quoteIDs) == 0 {
+			quoteIDs = pinnedCMCIDs()
+		}
+		for start := 0; start < len(quoteIDs); start += cmcQuotesBatchSize {
+			end := start + cmcQuotesBatchSize
+			if end > len(quoteIDs) {
+				end = len(quoteIDs)
+			}
+			if err := fetchCMCQuotes(job, client, baseURL, apiKeys, quoteIDs[start:end], output, seenIDs); err != nil {
+				return nil, err
+			}
+		}
+
+		seenIDs.warnUnpinnedDuplicates(job, "cmc")
+
 		return output, nil
 	}
 }
 
-func fetchCMCResource(host string, apiKey string, start int, limit int, output exchangeRates) (*cmcResponse, error) {
-	req, err := http.NewRequest("GET", buildCMCEndpoint(host), nil)
+func fetchCMCResource(job *health.Job, client *http.Client, baseURL string, apiKeys []string, start int, limit int, output exchangeRates, seenIDs symbolIDTracker) (*cmcResponse, error) {
+	q := url.Values{}
+	q.Add("start", fmt.Sprintf("%v", start))
+	q.Add("limit", fmt.Sprintf("%v", limit))
+	q.Add("convert", "BTC")
+
+	payload := &cmcResponse{}
+	err := rotateKeys(job, "cmc", len(apiKeys), func(key int) (int, error) {
+		return getCMCResource(client, buildCMCEndpoint(baseURL), apiKeys[key], q, payload)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	startStr := fmt.Sprintf("%v", start)
-	limitStr := fmt.Sprintf("%v", limit)
+	for _, entry := range payload.Data {
+		if err := addCMCEntry(entry, output, seenIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+func fetchCMCQuotes(job *health.Job, client *http.Client, baseURL string, apiKeys []string, ids []int64, output exchangeRates, seenIDs symbolIDTracker) error {
+	idStrs := make([]string, len(ids))
+	for i, id := range ids {
+		idStrs[i] = strconv.FormatInt(id, 10)
+	}
 
 	q := url.Values{}
-	q.Add("start", startStr)
-	q.Add("limit", limitStr)
+	q.Add("id", strings.Join(idStrs, ","))
 	q.Add("convert", "BTC")
 
+	payload := &cmcQuotesResponse{}
+	err := rotateKeys(job, "cmc", len(apiKeys), func(key int) (int, error) {
+		return getCMCResource(client, baseURL+cmcQuotesPath, apiKeys[key], q, payload)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Add the entries in the order requested so duplicates are warned about
+	// consistently
+	for _, id := range idStrs {
+		entry, ok := payload.Data[id]
+		if !ok {
+			continue
+		}
+		if err := addCMCEntry(entry, output, seenIDs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getCMCResource requests a CMC API endpoint and unmarshals the response into
+// payload. It returns the credits the request used.
+func getCMCResource(client *http.Client, endpoint string, apiKey string, q url.Values, payload interface{}) (int, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+
 	req.Header.Add("X-CMC_PRO_API_KEY", apiKey)
 	req.Header.Set("Accepts", "application/json")
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	payload := &cmcResponse{}
-	err = json.Unmarshal(body, payload)
-	if err != nil {
-		return nil, err
+	// Error responses report their credits too, so read them first
+	status := &cmcStatus{}
+	json.Unmarshal(body, status)
+	credits := status.Status.CreditCount
+
+	if resp.StatusCode != http.StatusOK {
+		return credits, errUnexpectedStatus{"cmc", resp.StatusCode}
 	}
 
-	for _, entry := range payload.Data {
-		entry.Symbol = CanonicalizeSymbol(entry.Symbol)
+	return credits, json.Unmarshal(body, payload)
+}
 
-		// Remove symbols that we don't want included in the API
-		if _, ok := bannedCryptoSymbols[entry.Symbol]; ok {
-			continue
-		}
+// addCMCEntry adds the rate of a CMC coin to output, unless its symbol is
+// banned or pinned to another coin
+func addCMCEntry(entry cmcEntry, output exchangeRates, seenIDs symbolIDTracker) error {
+	entry.Symbol = CanonicalizeSymbol(entry.Symbol)
 
-		// // Skip symbols that return price as null
-		// if entry.Quote.BTC.Price == nil {
-		// 	continue
-		// }
+	// Remove symbols that we don't want included in the API
+	if isBannedCryptoSymbol(entry.Symbol) {
+		return nil
+	}
 
-		if !IsCorrectIDForSymbol(entry.Symbol, entry.ID) {
-			continue
-		}
+	// // Skip symbols that return price as null
+	// if entry.Quote.BTC.Price == nil {
+	// 	continue
+	// }
 
-		price, err := invertAndFormatPrice(entry.Quote.BTC.Price.Value)
-		if err != nil {
-			return nil, err
-		}
+	seenIDs.add(entry.Symbol, entry.ID)
+	if !IsCorrectIDForSymbol(entry.Symbol, entry.ID) {
+		return nil
+	}
 
-		output[entry.Symbol] = exchangeRate{
-			Ask:  price,
-			Bid:  price,
-			Last: price,
-			Type: exchangeRateTypeCrypto.String(),
-		}
+	price, err := invertAndFormatPrice(entry.Quote.BTC.Price.Value)
+	if err != nil {
+		return err
 	}
 
-	return payload, nil
+	quote := entry.Quote.BTC
+	market := &marketData{
+		Rank:             entry.Rank,
+		Volume24hBTC:     quote.Volume24h,
+		MarketCapBTC:     quote.MarketCap,
+		PercentChange1h:  quote.PercentChange1h,
+		PercentChange24h: quote.PercentChange24h,
+		PercentChange7d:  quote.PercentChange7d,
+	}
+	if market.empty() {
+		market = nil
+	}
+
+	output[entry.Symbol] = exchangeRate{
+		Ask:    price,
+		Bid:    price,
+		Last:   price,
+		Type:   exchangeRateTypeCrypto.String(),
+		Name:   entry.Name,
+		CMCID:  entry.ID,
+		Market: market,
+	}
+	return nil
 }
 
-func buildCMCEndpoint(env string) string {
-	return fmt.Sprintf(cmcQueryEndpointTemplate, env)
+func buildCMCEndpoint(baseURL string) string {
+	return baseURL + cmcListingsPath
 }
@@ -0,0 +1,157 @@
+package ticker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestCMCQuotesFetcher(t *testing.T) {
+	provider := fakeprovider.New(1, 12)
+	quoteRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == cmcQuotesPath {
+			quoteRequests++
+		}
+		provider.ServeHTTP(rw, r)
+	}))
+	defer server.Close()
+
+	defaultBatchSize := cmcQuotesBatchSize
+	cmcQuotesBatchSize = 3
+	defer func() { cmcQuotesBatchSize = defaultBatchSize }()
+
+	job := health.NewStream().NewJob("test")
+	listings, err := NewCMCFetcher(server.URL, "")(job, httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pinned IDs are used by default, and the fake provider only knows
+	// some of them
+	_, err = NewCMCQuotesFetcher(server.URL, nil)(job, httpClient)
+	if err == nil {
+		t.Fatal("Expected unknown IDs to fail")
+	}
+
+	quoteRequests = 0
+	ids := []int64{1, 1027, 1831, 2, 1437, 328, 131, 74, 100001}
+	quotes, err := NewCMCQuotesFetcher(server.URL, ids)(job, httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quoteRequests != 3 {
+		t.Fatal("Expected the IDs to be fetched in 3 batches, got", quoteRequests)
+	}
+	if len(quotes) != len(ids) {
+		t.Fatal("Incorrect number of quotes:", len(quotes))
+	}
+	for symbol, rate := range quotes {
+		if !reflect.DeepEqual(rate, listings[symbol]) {
+			t.Fatalf("Expected the same rate for %s as from the listings: %+v != %+v", symbol, rate, listings[symbol])
+		}
+	}
+}
+
+func TestCMCQuotesFetcherFollowsPolicy(t *testing.T) {
+	provider := fakeprovider.New(1, 12)
+	requestedIDs := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == cmcQuotesPath {
+			requestedIDs = append(requestedIDs, r.URL.Query().Get("id"))
+		}
+		provider.ServeHTTP(rw, r)
+	}))
+	defer server.Close()
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	// A fetcher without IDs follows the pinned IDs of the current policy on
+	// every run rather than the policy it was first run with
+	fetch := NewCMCQuotesFetcher(server.URL, nil)
+	job := health.NewStream().NewJob("test")
+	for _, pinned := range []map[string]int64{
+		{"BTC": 1, "ETH": 1027},
+		{"BTC": 1, "LTC": 2},
+	} {
+		err := SetSymbolPolicy(SymbolPolicy{Pinned: pinned})
+		if err != nil {
+			t.Fatal(err)
+		}
+		quotes, err := fetch(job, httpClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for symbol := range pinned {
+			if _, ok := quotes[symbol]; !ok {
+				t.Fatal("Expected a quote for", symbol)
+			}
+		}
+	}
+
+	expected := []string{"1,1027", "1,2"}
+	if !reflect.DeepEqual(requestedIDs, expected) {
+		t.Fatalf("Incorrect IDs requested: %v != %v", requestedIDs, expected)
+	}
+}
+
+func TestCMCKeyRotation(t *testing.T) {
+	provider := fakeprovider.New(1, 12)
+	exhaustedRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-CMC_PRO_API_KEY") == "exhausted" {
+			exhaustedRequests++
+			rw.WriteHeader(http.StatusTooManyRequests)
+			rw.Write([]byte(`{"status": {"error_code": 1010, "credit_count": 0}}`))
+			return
+		}
+		provider.ServeHTTP(rw, r)
+	}))
+	defer server.Close()
+
+	apiKeysMu.Lock()
+	apiKeys = map[string]*providerKeys{}
+	apiKeysMu.Unlock()
+
+	sink := &testEventSink{}
+	stream := health.NewStream()
+	stream.AddSink(sink)
+
+	conf := DefaultConfig()
+	conf.CMCAPIKey = "exhausted"
+	conf.CMCAPIKeys = []string{"good"}
+	fetch := NewCMCFetcher(server.URL, conf.AllCMCAPIKeys()...)
+	for i := 0; i < 2; i++ {
+		rates, err := fetch(stream.NewJob("fetch"), httpClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := rates["ETH"]; !ok {
+			t.Fatal("Missing ETH rate")
+		}
+	}
+
+	// The exhausted key is only tried until the good one takes over
+	if exhaustedRequests != 1 {
+		t.Fatal("Expected the exhausted key to be tried once, got", exhaustedRequests)
+	}
+	if countTestEvents(sink, "keys.rotate.err") != 1 {
+		t.Fatal("Expected a single key rotation:", sink.events)
+	}
+
+	apiKeysMu.Lock()
+	usage := apiKeys["cmc"].usage
+	apiKeysMu.Unlock()
+	if usage[0].requests != 1 || usage[1].requests == 0 || usage[1].credits != usage[1].requests {
+		t.Fatal("Incorrect key usage:", usage)
+	}
+
+	// Every key being exhausted fails the fetch
+	_, err := NewCMCFetcher(server.URL, "exhausted")(stream.NewJob("fetch"), httpClient)
+	if err == nil {
+		t.Fatal("Expected an exhausted key to fail")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	ticker "github.com/OpenBazaar/tickerproxy"
+	"github.com/gocraft/health"
+)
+
+func fetch(stream *health.Stream, conf ticker.Config, _ []string) {
+	writers, err := ticker.NewWriters(conf)
+	if err != nil {
+		log.Fatalln("creating writers failed:", err)
+	}
+
+	ticker.StartHealthServers(stream)
+	_, err = ticker.FetchWithResult(stream, conf, writers...)
+	if err != nil {
+		log.Fatalln("ticker failed:", err)
+	}
+}
+
+// daemon fetches and writes rates on an interval and serves metrics over HTTP
+func daemon(stream *health.Stream, conf ticker.Config, _ []string) {
+	writers, err := ticker.NewWriters(conf)
+	if err != nil {
+		log.Fatalln("creating writers failed:", err)
+	}
+
+	ticker.StartHealthServers(stream)
+	listen(conf.ListenAddr, newMetricsMux(stream))
+	ticker.RunDaemon(stream, conf, stopOnSignal(), writers...)
+}
+
+// serve runs the daemon with an additional in-memory writer whose artifacts are
+// served over HTTP alongside the metrics
+func serve(stream *health.Stream, conf ticker.Config, _ []string) {
+	writers, err := ticker.NewWriters(conf)
+	if err != nil {
+		log.Fatalln("creating writers failed:", err)
+	}
+
+	memoryWriter := ticker.NewMemoryWriter()
+	writers = append(writers, ticker.NamedWriter{Name: "memory", Writer: memoryWriter.Write})
+
+	ticker.StartHealthServers(stream)
+	mux := newMetricsMux(stream)
+	mux.Handle("/", memoryWriter)
+	listen(conf.ListenAddr, mux)
+	ticker.RunDaemon(stream, conf, stopOnSignal(), writers...)
+}
+
+// newMetricsMux adds a Prometheus sink to the stream and returns a mux that
+// serves it on /metrics
+func newMetricsMux(stream *health.Stream) *http.ServeMux {
+	sink := ticker.NewPrometheusSink()
+	stream.AddSink(sink)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", sink)
+	return mux
+}
+
+// listen serves the handler in the background and exits if that fails
+func listen(addr string, handler http.Handler) {
+	go func() {
+		err := http.ListenAndServe(addr, handler)
+		if err != nil {
+			log.Fatalln("serving failed:", err)
+		}
+	}()
+}
+
+// diff prints what a fetch would change in the published api document without
+// writing anything. It exits non-zero if validation fails.
+func diff(stream *health.Stream, conf ticker.Config, _ []string) {
+	ratesDiff, err := ticker.Diff(stream, conf)
+	if ratesDiff == nil {
+		log.Fatalln("diff failed:", err)
+	}
+
+	for _, change := range ratesDiff.Added {
+		fmt.Printf("+ %-8s %s\n", change.Symbol, change.New)
+	}
+	for _, change := range ratesDiff.Removed {
+		fmt.Printf("- %-8s %s\n", change.Symbol, change.Old)
+	}
+	for _, change := range ratesDiff.Changed {
+		fmt.Printf("~ %-8s %s -> %s (%+.2f%%)\n", change.Symbol, change.Old, change.New, change.Change*100)
+	}
+	printReasons("overridden", ratesDiff.Overridden)
+	printReasons("filtered", ratesDiff.Filtered)
+	printReasons("dropped", ratesDiff.Dropped)
+	fmt.Printf("\n%d added, %d removed, %d changed, %d filtered, %d dropped\n",
+		len(ratesDiff.Added), len(ratesDiff.Removed), len(ratesDiff.Changed), len(ratesDiff.Filtered), len(ratesDiff.Dropped))
+
+	if err != nil {
+		log.Fatalln("validation failed:", err)
+	}
+}
+
+// validate checks an api document file against the validation rules. It exits
+// non-zero if validation fails.
+func validate(stream *health.Stream, conf ticker.Config, args []string) {
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	report, err := ticker.ValidateDocument(stream, conf, data)
+	printReasons("dropped", report.Dropped)
+	for _, failure := range report.Failures {
+		fmt.Printf("x %s\n", failure)
+	}
+	if err != nil {
+		log.Fatalln("validation failed:", err)
+	}
+	fmt.Println("ok")
+}
+
+func inspect(stream *health.Stream, conf ticker.Config, args []string) {
+	inspection, err := ticker.Inspect(stream, conf, args[0])
+	if err != nil {
+		log.Fatalln("inspect failed:", err)
+	}
+	printJSON(inspection)
+}
+
+// checkPins prints the symbols shared by several coins on CMC and how the
+// suggested pins differ from the current ones. It exits non-zero if they differ.
+func checkPins(stream *health.Stream, conf ticker.Config, _ []string) {
+	collisions, err := ticker.DiscoverSymbolCollisions(stream, conf.CMCBaseURLOrDefault(), conf.AllCMCAPIKeys()...)
+	if err != nil {
+		log.Fatalln("discovering symbol collisions failed:", err)
+	}
+
+	for _, collision := range collisions {
+		names := make([]string, len(collision.Coins))
+		for i, coin := range collision.Coins {
+			names[i] = fmt.Sprintf("%d %s (rank %d)", coin.ID, coin.Name, coin.Rank)
+		}
+		fmt.Printf("%-8s %s\n", collision.Symbol, strings.Join(names, ", "))
+	}
+
+	changes := ticker.DiffPins(collisions)
+	fmt.Printf("\n%d shared symbols, %d pin changes\n", len(collisions), len(changes))
+	for _, change := range changes {
+		switch {
+		case change.From == 0:
+			fmt.Printf("+ %-8s %d\n", change.Symbol, change.To)
+		case change.To == 0:
+			fmt.Printf("- %-8s %d (no longer shared)\n", change.Symbol, change.From)
+		default:
+			fmt.Printf("~ %-8s %d -> %d\n", change.Symbol, change.From, change.To)
+		}
+	}
+
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printConfig(_ *health.Stream, conf ticker.Config, _ []string) {
+	printJSON(conf.Redacted())
+}
+
+// printReasons prints the symbols removed from a run and why
+func printReasons(action string, reasons map[string]string) {
+	symbols := make([]string, 0, len(reasons))
+	for symbol := range reasons {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		fmt.Printf("! %-8s %s: %s\n", symbol, action, reasons[symbol])
+	}
+}
+
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(string(out))
+}
+
+// stopOnSignal returns a channel that is closed on SIGINT or SIGTERM
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	return stop
+}
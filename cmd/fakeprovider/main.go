@@ -0,0 +1,47 @@
+// Command fakeprovider serves fake provider APIs and exchange feeds for
+// integration tests and development. Point a ticker at it with the provider
+// base URLs, e.g. btcavg_base_url and cmc_base_url.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+)
+
+func main() {
+	var (
+		addr          = flag.String("addr", ":9090", "address to listen on")
+		btcavgPubkey  = flag.String("btcavg_pubkey", "", "BitcoinAverage public key to accept")
+		btcavgPrivkey = flag.String("btcavg_privkey", "", "BitcoinAverage private key to check signatures with; empty accepts any request")
+		cmcAPIKey     = flag.String("cmc_api_key", "", "CMC API key to accept; empty accepts any request")
+		scenarios     = flag.String("scenarios", "", "comma separated scenarios: random_walk, rate_limit, null_prices, malformed_json, slow")
+		delay         = flag.Duration("delay", 0, "response delay in the slow scenario (default 5s)")
+		streamEvery   = flag.Duration("stream_interval", 0, "time between tickers on the WebSocket feeds (default 1s)")
+		coins         = flag.Int("coins", 50, "number of generated coins to serve")
+		seed          = flag.Int64("seed", 1, "seed for the generated prices")
+	)
+	flag.Parse()
+
+	provider := fakeprovider.New(*seed, *coins)
+	provider.BTCAVGPubkey = *btcavgPubkey
+	provider.BTCAVGPrivkey = *btcavgPrivkey
+	provider.CMCAPIKey = *cmcAPIKey
+	if *delay > 0 {
+		provider.Delay = *delay
+	}
+	if *streamEvery > 0 {
+		provider.StreamInterval = *streamEvery
+	}
+
+	err := provider.SetScenarios(strings.Split(*scenarios, ",")...)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Println("serving fake providers on", *addr, "with scenarios", provider.Scenarios())
+	log.Fatalln(http.ListenAndServe(*addr, provider))
+}
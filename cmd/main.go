@@ -1,53 +1,109 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	ticker "github.com/OpenBazaar/tickerproxy"
 	"github.com/gocraft/health"
-	"github.com/gocraft/health/sinks/bugsnag"
 )
 
+// command is a subcommand of the ticker binary
+type command struct {
+	name  string
+	args  []string
+	usage string
+	run   func(stream *health.Stream, conf ticker.Config, args []string)
+}
+
+var commands = []command{
+	{"fetch", nil, "fetch rates once and write them", fetch},
+	{"daemon", nil, "fetch and write rates on an interval and serve metrics", daemon},
+	{"serve", nil, "fetch rates on an interval and serve them over HTTP", serve},
+	{"diff", nil, "show what a fetch would change in the published api", diff},
+	{"validate", []string{"file"}, "check an api document against the validation rules", validate},
+	{"inspect", []string{"symbol"}, "show how a symbol is fetched, merged and published", inspect},
+	{"pins check", nil, "compare the pinned symbols against CMC symbol collisions", checkPins},
+	{"config print", nil, "print the effective config with secrets redacted", printConfig},
+}
+
 func main() {
-	conf := ticker.NewConfig()
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.Usage = func() { usage(flags) }
+	configPath := flags.String("config", os.Getenv("TICKER_CONFIG_PATH"), "path to a JSON config file, the only supported format (env TICKER_CONFIG_PATH)")
+	configFlags := ticker.NewConfigFlags(flags)
+	flags.Parse(os.Args[1:])
+
+	// Without a command the binary fetches once, as it did before it had
+	// commands, so existing cron jobs keep publishing
+	commandArgs := flags.Args()
+	if len(commandArgs) == 0 {
+		commandArgs = []string{"fetch"}
+	}
+
+	cmd, args, ok := findCommand(commandArgs)
+	if !ok {
+		flags.Usage()
+		os.Exit(2)
+	}
 
-	writers, err := getWriters(conf.OutPath, conf.AWSS3Region, conf.AWSS3Bucket)
+	conf, err := ticker.LoadConfig(*configPath)
 	if err != nil {
-		log.Fatalln("creating writers failed:", err)
+		log.Fatalln("loading config failed:", err)
 	}
+	configFlags.Apply(&conf)
 
-	err = ticker.Fetch(newHealthStream(conf.BugsnagAPIKey), conf, writers...)
+	err = conf.ResolveSecrets()
 	if err != nil {
-		log.Fatalln("ticker failed:", err)
+		log.Fatalln("resolving secrets failed:", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		log.Fatalln(err)
 	}
-}
 
-func newHealthStream(bugsnagAPIKey string) *health.Stream {
-	stream := health.NewStream()
-	stream.AddSink(&health.WriterSink{os.Stdout})
+	err = ticker.ApplySymbolPolicy(conf)
+	if err != nil {
+		log.Fatalln("loading symbol policy failed:", err)
+	}
 
-	if bugsnagAPIKey != "" {
-		stream.AddSink(bugsnag.NewSink(&bugsnag.Config{APIKey: bugsnagAPIKey}))
+	stream, err := ticker.NewHealthStream(conf)
+	if err != nil {
+		log.Fatalln("creating health stream failed:", err)
 	}
 
-	return stream
+	cmd.run(stream, conf, args)
 }
 
-func getWriters(outfile string, s3Region string, s3Bucket string) ([]ticker.Writer, error) {
-	writers := []ticker.Writer{}
-
-	if outfile != "" {
-		writers = append(writers, ticker.NewFileSystemWriter(outfile))
+// findCommand returns the command named by the leading arguments and the
+// arguments that follow it
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		name := strings.Fields(cmd.name)
+		if len(args) != len(name)+len(cmd.args) {
+			continue
+		}
+		if strings.Join(args[:len(name)], " ") == cmd.name {
+			return cmd, args[len(name):], true
+		}
 	}
+	return command{}, nil, false
+}
 
-	if s3Region != "" {
-		writer, err := ticker.NewS3Writer(s3Region, s3Bucket)
-		if err != nil {
-			return nil, err
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [args]\n\nCommands, fetch when none is given:\n", os.Args[0])
+	for _, cmd := range commands {
+		name := cmd.name
+		for _, arg := range cmd.args {
+			name += " <" + arg + ">"
 		}
-		writers = append(writers, writer)
+		fmt.Fprintf(out, "  %-18s %s\n", name, cmd.usage)
 	}
-
-	return writers, nil
+	fmt.Fprintf(out, "\nFlags:\n")
+	flags.PrintDefaults()
 }
@@ -0,0 +1,81 @@
+package ticker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gocraft/health"
+)
+
+const (
+	coinbaseDefaultBaseURL = "https://api.exchange.coinbase.com"
+	coinbaseProductsPath   = "/products/"
+)
+
+type coinbaseTicker struct {
+	Ask   json.Number `json:"ask"`
+	Bid   json.Number `json:"bid"`
+	Price json.Number `json:"price"`
+}
+
+// NewCoinbaseFetcher returns the rates of the given Coinbase products, e.g.
+// BTC-USD or ETH-BTC. Products that don't involve BTC, e.g. ETH-USDT, are
+// returned as cross quotes used by cross triangulation.
+func NewCoinbaseFetcher(baseURL string, products []string) fetchFn {
+	return func(job *health.Job, client *http.Client) (exchangeRates, error) {
+		rates := exchangeRates{}
+		for _, product := range products {
+			parts := strings.SplitN(product, "-", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			ticker, err := fetchCoinbaseTicker(client, baseURL, product)
+			if err != nil {
+				return nil, err
+			}
+
+			base, quote := CanonicalizeSymbol(parts[0]), CanonicalizeSymbol(parts[1])
+			symbol, rate, ok, err := pairRate(base, quote, ticker.Ask, ticker.Bid, ticker.Price)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				rates[symbol] = rate
+			}
+		}
+		return rates, nil
+	}
+}
+
+// fetchCoinbaseTicker gets the ticker of a single Coinbase product
+func fetchCoinbaseTicker(client *http.Client, baseURL string, product string) (*coinbaseTicker, error) {
+	req, err := http.NewRequest("GET", baseURL+coinbaseProductsPath+product+"/ticker", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errUnexpectedStatus{"coinbase", resp.StatusCode}
+	}
+
+	ticker := &coinbaseTicker{}
+	err = json.Unmarshal(body, ticker)
+	if err != nil {
+		return nil, err
+	}
+	return ticker, nil
+}
@@ -1,35 +1,453 @@
 package ticker
 
-import "os"
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
 
+const redactedConfigValue = "[redacted]"
+
+// Config holds the settings for a run. It is built from defaults, an optional
+// JSON config file, environment variables and CLI flags, in increasing order of
+// precedence.
 type Config struct {
-	OutPath       string
-	AWSS3Region   string
-	AWSS3Bucket   string
-	BTCAVGPubkey  string
-	BTCAVGPrivkey string
-	CMCAPIKey     string
-	CMCEnv        string
-	BugsnagAPIKey string
+	OutPath       string `json:"out_path"`
+	AWSS3Region   string `json:"aws_s3_region"`
+	AWSS3Bucket   string `json:"aws_s3_bucket"`
+	BTCAVGPubkey  string `json:"btcavg_pubkey"`
+	BTCAVGPrivkey string `json:"btcavg_privkey"`
+	CMCAPIKey     string `json:"cmc_api_key"`
+	CMCEnv        string `json:"cmc_env"`
+
+	// BTCAVGKeys and CMCAPIKeys are more keys for the providers, tried in order
+	// after the keys above when a key is rate limited or rejected
+	BTCAVGKeys []BTCAVGKey `json:"btcavg_keys,omitempty"`
+	CMCAPIKeys []string    `json:"cmc_api_keys,omitempty"`
+
+	// CMCMode is how CMC rates are fetched: listings pages through every coin
+	// and quotes only fetches CMCIDs, or the pinned IDs when there are none
+	CMCMode string  `json:"cmc_mode"`
+	CMCIDs  []int64 `json:"cmc_ids,omitempty"`
+
+	// The base URLs override the provider API URLs, e.g. to point at a fake
+	// provider. CMCBaseURL defaults to the URL for CMCEnv.
+	BTCAVGBaseURL   string `json:"btcavg_base_url"`
+	CMCBaseURL      string `json:"cmc_base_url"`
+	ECBBaseURL      string `json:"ecb_base_url"`
+	KrakenBaseURL   string `json:"kraken_base_url"`
+	CoinbaseBaseURL string `json:"coinbase_base_url"`
+
+	// KrakenPairs and CoinbaseProducts are the exchange markets to fetch, e.g.
+	// XBTUSD and ETH-BTC. Each exchange is only fetched when it has markets.
+	KrakenPairs      []string `json:"kraken_pairs,omitempty"`
+	CoinbaseProducts []string `json:"coinbase_products,omitempty"`
+
+	BugsnagAPIKey string `json:"bugsnag_api_key"`
+	Interval      string `json:"interval"`
+	ListenAddr    string `json:"listen_addr"`
+
+	SymbolPolicyPath string `json:"symbol_policy_path"`
+
+	// OverridesPath is a file of per-symbol overrides, read every run
+	OverridesPath string `json:"overrides_path"`
+
+	// Providers limits fetching to the named providers. Empty means all.
+	Providers []string `json:"providers,omitempty"`
+
+	// OptionalProviders are providers whose errors don't fail the run. The
+	// other providers' rates are published without theirs.
+	OptionalProviders []string `json:"optional_providers,omitempty"`
+
+	// Symbols limits the published documents to these symbols. The run is
+	// still validated against every fetched symbol. Empty means all.
+	Symbols []string `json:"symbols,omitempty"`
+
+	ValidationRules []ValidationRule `json:"validation_rules"`
+	Smoothing       []SmoothingRule  `json:"smoothing,omitempty"`
+	MarketFloors    MarketFloors     `json:"market_floors"`
+	Health          HealthConfig     `json:"health"`
+	Cache           CacheConfig      `json:"cache"`
+	FX              FXConfig         `json:"fx"`
+	Cross           CrossConfig      `json:"cross"`
+	Stream          StreamConfig     `json:"stream"`
+	Secrets         SecretsConfig    `json:"secrets"`
+
+	// secretValues are the values resolved from secrets, which are redacted
+	// wherever the config is shown
+	secretValues []string
 }
 
-func NewConfig() Config {
+// configVar describes how a single Config field is set from the environment
+// and the command line
+type configVar struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	field  func(*Config) *string
+}
+
+var configVars = []configVar{
+	{"out_path", "TICKER_OUT_PATH", "directory to write outputs to", false, func(c *Config) *string { return &c.OutPath }},
+	{"aws_s3_region", "AWS_S3_REGION", "AWS region to write outputs to", false, func(c *Config) *string { return &c.AWSS3Region }},
+	{"aws_s3_bucket", "AWS_S3_BUCKET", "AWS S3 bucket to write outputs to", false, func(c *Config) *string { return &c.AWSS3Bucket }},
+	{"btcavg_pubkey", "TICKER_BTCAVG_PUBKEY", "API public key from bitcoinaverage.com", false, func(c *Config) *string { return &c.BTCAVGPubkey }},
+	{"btcavg_privkey", "TICKER_BTCAVG_PRIVKEY", "API private key from bitcoinaverage.com", true, func(c *Config) *string { return &c.BTCAVGPrivkey }},
+	{"btcavg_base_url", "TICKER_BTCAVG_BASE_URL", "base URL of the bitcoinaverage.com API (default https://apiv2.bitcoinaverage.com)", false, func(c *Config) *string { return &c.BTCAVGBaseURL }},
+	{"cmc_api_key", "TICKER_CMC_API_KEY", "API key from coinmarketcap.com", true, func(c *Config) *string { return &c.CMCAPIKey }},
+	{"cmc_env", "TICKER_CMC_ENV", "CoinMarketCap environment (sandbox or pro)", false, func(c *Config) *string { return &c.CMCEnv }},
+	{"cmc_mode", "TICKER_CMC_MODE", "how to fetch CMC rates: listings of every coin or quotes of cmc_ids", false, func(c *Config) *string { return &c.CMCMode }},
+	{"cmc_base_url", "TICKER_CMC_BASE_URL", "base URL of the CoinMarketCap API (default selected by cmc_env)", false, func(c *Config) *string { return &c.CMCBaseURL }},
+	{"ecb_base_url", "TICKER_ECB_BASE_URL", "base URL of the ECB reference rates (default https://www.ecb.europa.eu)", false, func(c *Config) *string { return &c.ECBBaseURL }},
+	{"kraken_base_url", "TICKER_KRAKEN_BASE_URL", "base URL of the Kraken API (default https://api.kraken.com)", false, func(c *Config) *string { return &c.KrakenBaseURL }},
+	{"coinbase_base_url", "TICKER_COINBASE_BASE_URL", "base URL of the Coinbase Exchange API (default https://api.exchange.coinbase.com)", false, func(c *Config) *string { return &c.CoinbaseBaseURL }},
+	{"fx_mode", "TICKER_FX_MODE", "derive fiat rates from ECB reference rates: off, fallback or replace", false, func(c *Config) *string { return &c.FX.Mode }},
+	{"fx_anchor", "TICKER_FX_ANCHOR", "fiat symbol whose BTC rate anchors derived fiat rates: USD or EUR", false, func(c *Config) *string { return &c.FX.Anchor }},
+	{"bugsnag_api_key", "TICKER_BUGSNAG_API_KEY", "Bugsnag key for error monitoring", true, func(c *Config) *string { return &c.BugsnagAPIKey }},
+	{"interval", "TICKER_INTERVAL", "time between runs in daemon and serve mode", false, func(c *Config) *string { return &c.Interval }},
+	{"listen_addr", "TICKER_LISTEN_ADDR", "address to serve HTTP on in daemon and serve mode", false, func(c *Config) *string { return &c.ListenAddr }},
+	{"symbol_policy_path", "TICKER_SYMBOL_POLICY_PATH", "path or s3:// URL of a symbol policy file", false, func(c *Config) *string { return &c.SymbolPolicyPath }},
+	{"secrets_backend", "TICKER_SECRETS_BACKEND", "where secret:NAME config values are read from: env, file or dir", false, func(c *Config) *string { return &c.Secrets.Backend }},
+	{"secrets_path", "TICKER_SECRETS_PATH", "JSON file or directory of secrets for the file and dir backends", false, func(c *Config) *string { return &c.Secrets.Path }},
+	{"overrides_path", "TICKER_OVERRIDES_PATH", "path or s3:// URL of a per-symbol overrides file, read every run", false, func(c *Config) *string { return &c.OverridesPath }},
+	{"cache_path", "TICKER_CACHE_PATH", "directory or s3:// URL to cache provider responses in", false, func(c *Config) *string { return &c.Cache.Path }},
+}
+
+// DefaultConfig returns a Config with every setting at its default value
+func DefaultConfig() Config {
 	return Config{
-		OutPath:       getEnvString("TICKER_OUT_PATH", "./"),
-		AWSS3Region:   getEnvString("AWS_S3_REGION", ""),
-		AWSS3Bucket:   getEnvString("AWS_S3_BUCKET", ""),
-		BTCAVGPubkey:  getEnvString("TICKER_BTCAVG_PUBKEY", ""),
-		BTCAVGPrivkey: getEnvString("TICKER_BTCAVG_PRIVKEY", ""),
-		CMCAPIKey:     getEnvString("TICKER_CMC_API_KEY", ""),
-		CMCEnv:        getEnvString("TICKER_CMC_ENV", "sandbox"),
-		BugsnagAPIKey: getEnvString("TICKER_BUGSNAG_API_KEY", ""),
+		OutPath:         "./",
+		CMCEnv:          "sandbox",
+		CMCMode:         CMCModeListings,
+		Interval:        "1m",
+		ListenAddr:      ":8080",
+		ValidationRules: DefaultValidationRules(),
+		Health:          DefaultHealthConfig(),
+		FX:              DefaultFXConfig(),
+		Cross:           DefaultCrossConfig(),
+		Stream:          DefaultStreamConfig(),
+		Secrets:         DefaultSecretsConfig(),
+	}
+}
+
+// NewConfig returns the default Config overridden by environment variables
+func NewConfig() Config {
+	conf := DefaultConfig()
+	conf.applyEnv()
+	return conf
+}
+
+// LoadConfig builds a Config from the defaults, the JSON file at the given path
+// if it is not empty, and environment variables. Unknown keys in the file are
+// rejected. JSON is the only supported format.
+func LoadConfig(path string) (Config, error) {
+	conf := DefaultConfig()
+
+	if path != "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".toml":
+			return Config{}, errInvalidConfig(fmt.Sprintf("config file %s must be JSON", path))
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&conf)
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing config file %s: %s", path, err)
+		}
+	}
+
+	conf.applyEnv()
+	return conf, nil
+}
+
+func (c *Config) applyEnv() {
+	for _, v := range configVars {
+		if val := os.Getenv(v.env); val != "" {
+			*v.field(c) = val
+		}
+	}
+
+	if bypass, err := strconv.ParseBool(os.Getenv("TICKER_CACHE_BYPASS")); err == nil {
+		c.Cache.Bypass = bypass
+	}
+}
+
+// Validate checks that the Config is usable
+func (c Config) Validate() error {
+	if c.CMCEnv != "sandbox" && c.CMCEnv != "pro" {
+		return errInvalidConfig(fmt.Sprintf("cmc_env must be sandbox or pro, got %q", c.CMCEnv))
+	}
+
+	if c.CMCMode != CMCModeListings && c.CMCMode != CMCModeQuotes {
+		return errInvalidConfig(fmt.Sprintf("cmc_mode must be listings or quotes, got %q", c.CMCMode))
+	}
+	for _, id := range c.CMCIDs {
+		if id <= 0 {
+			return errInvalidConfig(fmt.Sprintf("cmc_ids must be positive, got %d", id))
+		}
+	}
+
+	if c.AWSS3Region != "" && c.AWSS3Bucket == "" {
+		return errInvalidConfig("aws_s3_bucket is required when aws_s3_region is set")
+	}
+
+	if c.AWSS3Bucket != "" && c.AWSS3Region == "" {
+		return errInvalidConfig("aws_s3_region is required when aws_s3_bucket is set")
+	}
+
+	if c.BTCAVGPrivkey != "" && c.BTCAVGPubkey == "" {
+		return errInvalidConfig("btcavg_pubkey is required when btcavg_privkey is set")
+	}
+
+	for _, key := range c.BTCAVGKeys {
+		if key.Pubkey == "" || key.Privkey == "" {
+			return errInvalidConfig("btcavg_keys need a pubkey and a privkey")
+		}
+	}
+	for _, key := range c.CMCAPIKeys {
+		if key == "" {
+			return errInvalidConfig("cmc_api_keys must not be empty")
+		}
+	}
+
+	if interval, err := time.ParseDuration(c.Interval); err != nil || interval <= 0 {
+		return errInvalidConfig(fmt.Sprintf("interval must be a positive duration, got %q", c.Interval))
+	}
+
+	for _, baseURL := range []string{c.BTCAVGBaseURL, c.CMCBaseURL, c.ECBBaseURL, c.KrakenBaseURL, c.CoinbaseBaseURL} {
+		if baseURL == "" {
+			continue
+		}
+		if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errInvalidConfig(fmt.Sprintf("provider base URLs must be http or https URLs, got %q", baseURL))
+		}
+	}
+
+	for _, name := range append(append([]string{}, c.Providers...), c.OptionalProviders...) {
+		if !isProviderName(name) {
+			return errInvalidConfig(fmt.Sprintf("unknown provider %q", name))
+		}
+	}
+
+	for _, product := range c.CoinbaseProducts {
+		if parts := strings.Split(product, "-"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errInvalidConfig(fmt.Sprintf("coinbase products must be BASE-QUOTE, got %q", product))
+		}
+	}
+	for _, pair := range c.KrakenPairs {
+		if _, _, ok := parseKrakenPair(pair); !ok {
+			return errInvalidConfig(fmt.Sprintf("unsupported kraken pair %q", pair))
+		}
+	}
+
+	for _, rule := range c.ValidationRules {
+		if err := rule.validate(); err != nil {
+			return errInvalidConfig(err.Error())
+		}
+	}
+
+	for _, rule := range c.Smoothing {
+		if err := rule.validate(); err != nil {
+			return errInvalidConfig(err.Error())
+		}
+	}
+
+	if err := c.MarketFloors.validate(); err != nil {
+		return errInvalidConfig(err.Error())
+	}
+
+	for _, sink := range c.Health.Sinks {
+		if err := sink.validate(); err != nil {
+			return errInvalidConfig(err.Error())
+		}
+	}
+
+	if err := c.Cache.validate(); err != nil {
+		return errInvalidConfig(err.Error())
+	}
+
+	if err := c.Secrets.validate(); err != nil {
+		return errInvalidConfig(err.Error())
+	}
+
+	if err := c.Cross.validate(); err != nil {
+		return errInvalidConfig(err.Error())
+	}
+
+	if err := c.Stream.validate(); err != nil {
+		return errInvalidConfig(err.Error())
+	}
+	if containsString(c.Stream.Exchanges, "kraken") && len(c.KrakenPairs) == 0 {
+		return errInvalidConfig("kraken_pairs are required to stream from kraken")
+	}
+	if containsString(c.Stream.Exchanges, "coinbase") && len(c.CoinbaseProducts) == 0 {
+		return errInvalidConfig("coinbase_products are required to stream from coinbase")
+	}
+
+	if err := c.FX.validate(); err != nil {
+		return errInvalidConfig(err.Error())
+	}
+	if c.FX.enabled() && len(c.Providers) > 0 && !containsString(c.Providers, "ecb") {
+		return errInvalidConfig("providers must include ecb when fx mode is " + c.FX.Mode)
+	}
+
+	return nil
+}
+
+// BTCAVGBaseURLOrDefault returns the base URL of the BitcoinAverage API
+func (c Config) BTCAVGBaseURLOrDefault() string {
+	if c.BTCAVGBaseURL != "" {
+		return strings.TrimSuffix(c.BTCAVGBaseURL, "/")
+	}
+	return btcavgDefaultBaseURL
+}
+
+// ECBBaseURLOrDefault returns the base URL of the ECB reference rates
+func (c Config) ECBBaseURLOrDefault() string {
+	if c.ECBBaseURL != "" {
+		return strings.TrimSuffix(c.ECBBaseURL, "/")
+	}
+	return ecbDefaultBaseURL
+}
+
+// KrakenBaseURLOrDefault returns the base URL of the Kraken API
+func (c Config) KrakenBaseURLOrDefault() string {
+	if c.KrakenBaseURL != "" {
+		return strings.TrimSuffix(c.KrakenBaseURL, "/")
+	}
+	return krakenDefaultBaseURL
+}
+
+// CoinbaseBaseURLOrDefault returns the base URL of the Coinbase Exchange API
+func (c Config) CoinbaseBaseURLOrDefault() string {
+	if c.CoinbaseBaseURL != "" {
+		return strings.TrimSuffix(c.CoinbaseBaseURL, "/")
+	}
+	return coinbaseDefaultBaseURL
+}
+
+// CMCBaseURLOrDefault returns the base URL of the CMC API
+func (c Config) CMCBaseURLOrDefault() string {
+	if c.CMCBaseURL != "" {
+		return strings.TrimSuffix(c.CMCBaseURL, "/")
+	}
+	return fmt.Sprintf(cmcBaseURLTemplate, c.CMCEnv)
+}
+
+// AllBTCAVGKeys returns the BitcoinAverage keys in the order they are tried:
+// btcavg_pubkey and btcavg_privkey, if set, then btcavg_keys
+func (c Config) AllBTCAVGKeys() []BTCAVGKey {
+	keys := []BTCAVGKey{}
+	if c.BTCAVGPubkey != "" || len(c.BTCAVGKeys) == 0 {
+		keys = append(keys, BTCAVGKey{Pubkey: c.BTCAVGPubkey, Privkey: c.BTCAVGPrivkey})
 	}
+	return append(keys, c.BTCAVGKeys...)
+}
+
+// AllCMCAPIKeys returns the CMC API keys in the order they are tried:
+// cmc_api_key, if set, then cmc_api_keys
+func (c Config) AllCMCAPIKeys() []string {
+	keys := []string{}
+	if c.CMCAPIKey != "" || len(c.CMCAPIKeys) == 0 {
+		keys = append(keys, c.CMCAPIKey)
+	}
+	return append(keys, c.CMCAPIKeys...)
+}
+
+// IntervalDuration returns the time between runs in daemon and serve mode
+func (c Config) IntervalDuration() time.Duration {
+	interval, _ := time.ParseDuration(c.Interval)
+	return interval
 }
 
-func getEnvString(key string, defaultVal string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		val = defaultVal
+// Redacted returns a copy of the Config with all secret values replaced
+func (c Config) Redacted() Config {
+	for _, v := range configVars {
+		if field := v.field(&c); v.secret && *field != "" {
+			*field = redactedConfigValue
+		}
+	}
+
+	btcavgKeys := make([]BTCAVGKey, len(c.BTCAVGKeys))
+	for i, key := range c.BTCAVGKeys {
+		key.Privkey = redactedConfigValue
+		btcavgKeys[i] = key
+	}
+	c.BTCAVGKeys = btcavgKeys
+
+	cmcAPIKeys := make([]string, len(c.CMCAPIKeys))
+	for i := range c.CMCAPIKeys {
+		cmcAPIKeys[i] = redactedConfigValue
+	}
+	c.CMCAPIKeys = cmcAPIKeys
+
+	sinks := make([]HealthSinkConfig, len(c.Health.Sinks))
+	for i, sink := range c.Health.Sinks {
+		if sink.APIKey != "" {
+			sink.APIKey = redactedConfigValue
+		}
+		sinks[i] = sink
 	}
-	return val
+	c.Health.Sinks = sinks
+
+	for _, field := range c.secretFields() {
+		if c.isSecretValue(*field) {
+			*field = redactedConfigValue
+		}
+	}
+
+	return c
+}
+
+// ConfigFlags binds Config settings to command line flags
+type ConfigFlags struct {
+	flags       *flag.FlagSet
+	values      map[string]*string
+	cacheBypass *bool
+}
+
+// NewConfigFlags registers a flag for each Config setting on the given FlagSet
+func NewConfigFlags(flags *flag.FlagSet) *ConfigFlags {
+	cf := &ConfigFlags{flags: flags, values: map[string]*string{}}
+	for _, v := range configVars {
+		cf.values[v.key] = flags.String(v.key, "", fmt.Sprintf("%s (env %s)", v.usage, v.env))
+	}
+	cf.cacheBypass = flags.Bool("cache_bypass", false, "ignore cached provider responses (env TICKER_CACHE_BYPASS)")
+	return cf
+}
+
+// Apply overrides the Config with the flags that were set on the command line
+func (cf *ConfigFlags) Apply(c *Config) {
+	set := map[string]bool{}
+	cf.flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, v := range configVars {
+		if set[v.key] {
+			*v.field(c) = *cf.values[v.key]
+		}
+	}
+	if set["cache_bypass"] {
+		c.Cache.Bypass = *cf.cacheBypass
+	}
+}
+
+type errInvalidConfig string
+
+func (e errInvalidConfig) Error() string {
+	return "Invalid config: " + string(e)
 }
@@ -0,0 +1,98 @@
+package ticker
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := writeTestConfigFile(t, `{"cmc_env": "pro", "cmc_api_key": "file-key", "out_path": "/tmp/file"}`)
+	defer os.Remove(path)
+
+	os.Setenv("TICKER_OUT_PATH", "/tmp/env")
+	defer os.Unsetenv("TICKER_OUT_PATH")
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.CMCEnv != "pro" || conf.CMCAPIKey != "file-key" {
+		t.Fatal("Config file values were not loaded:", conf)
+	}
+	if conf.OutPath != "/tmp/env" {
+		t.Fatal("Env var did not override config file:", conf.OutPath)
+	}
+
+	// Flags override everything
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := NewConfigFlags(flags)
+	err = flags.Parse([]string{"-out_path", "/tmp/flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFlags.Apply(&conf)
+	if conf.OutPath != "/tmp/flag" || conf.CMCEnv != "pro" {
+		t.Fatal("Flags were not applied correctly:", conf)
+	}
+
+	redacted := conf.Redacted()
+	if redacted.CMCAPIKey != redactedConfigValue || redacted.BTCAVGPrivkey != "" || redacted.CMCEnv != "pro" {
+		t.Fatal("Incorrect redaction:", redacted)
+	}
+	if conf.CMCAPIKey != "file-key" {
+		t.Fatal("Redacted modified the original config")
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	path := writeTestConfigFile(t, `{"cmc_enviroment": "pro"}`)
+	defer os.Remove(path)
+
+	_, err := LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "cmc_enviroment") {
+		t.Fatal("Expected unknown key error, got:", err)
+	}
+}
+
+func TestLoadConfigRejectsOtherFormats(t *testing.T) {
+	_, err := LoadConfig("/tmp/ticker_proxy_config.yaml")
+	if _, ok := err.(errInvalidConfig); !ok {
+		t.Fatal("Expected YAML config to be rejected, got:", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, update := range []func(*Config){
+		func(c *Config) { c.CMCEnv = "prod" },
+		func(c *Config) { c.AWSS3Region = "us-east-1" },
+		func(c *Config) { c.AWSS3Bucket = "bucket" },
+		func(c *Config) { c.BTCAVGPrivkey = "privkey" },
+		func(c *Config) { c.BTCAVGKeys = []BTCAVGKey{{Pubkey: "pubkey"}} },
+		func(c *Config) { c.Interval = "0s" },
+		func(c *Config) { c.ValidationRules = []ValidationRule{{Type: RuleBand, Action: RuleActionFail}} },
+	} {
+		conf := DefaultConfig()
+		update(&conf)
+		if _, ok := conf.Validate().(errInvalidConfig); !ok {
+			t.Fatal("Expected config to be invalid:", conf)
+		}
+	}
+
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestConfigFile(t *testing.T, contents string) string {
+	path := fmt.Sprintf("/tmp/ticker_proxy_config_%d.json", rand.Int())
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,169 @@
+package ticker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gocraft/health"
+)
+
+// CrossConfig configures deriving rates that no provider quotes against BTC
+// through intermediate symbols, e.g. FOO from a FOO/ETH market and ETH's rate
+type CrossConfig struct {
+	// Intermediates are the symbols rates may be derived through, in order of
+	// preference
+	Intermediates []string `json:"intermediates"`
+
+	// MaxHops is the most rates a derived rate may be computed from, counting
+	// the intermediate's own rate. 2 allows FOO/ETH with ETH's rate and 3
+	// allows FOO/USDT with USDT/ETH and ETH's rate.
+	MaxHops int `json:"max_hops"`
+}
+
+// DefaultCrossConfig returns the cross config used when none is configured
+func DefaultCrossConfig() CrossConfig {
+	return CrossConfig{Intermediates: []string{}, MaxHops: 2}
+}
+
+// enabled checks if any rates are derived through intermediates
+func (c CrossConfig) enabled() bool {
+	return len(c.Intermediates) > 0
+}
+
+// validate checks that the cross config is well formed
+func (c CrossConfig) validate() error {
+	for _, symbol := range c.Intermediates {
+		if symbol == "" || symbol == "BTC" {
+			return fmt.Errorf("cross intermediates must be symbols other than BTC, got %q", symbol)
+		}
+	}
+	if c.enabled() && c.MaxHops < 2 {
+		return fmt.Errorf("cross max_hops must be at least 2, got %d", c.MaxHops)
+	}
+	return nil
+}
+
+// triangulateCross derives the rates of symbols missing from rates through the
+// intermediates' rates and the cross quotes, keyed by BASE/QUOTE. Rates are the
+// units of each symbol per base, which is BTC for the published documents.
+// Rates are only derived within the hop limit, through the shortest path, and
+// intermediates are tried in order at each length. Existing rates are kept.
+func triangulateCross(job *health.Job, conf CrossConfig, rates exchangeRates, quotes exchangeRates) {
+	markets := make([]string, 0, len(quotes))
+	for market := range quotes {
+		markets = append(markets, market)
+	}
+	sort.Strings(markets)
+
+	// hops holds the number of rates behind each usable intermediate's rate
+	hops := map[string]int{}
+	for _, symbol := range conf.Intermediates {
+		if rate, ok := rates[symbol]; ok {
+			hops[symbol] = len(ratePath(symbol, rate))
+		}
+	}
+
+	derived := 0
+	for hop := 2; hop <= conf.MaxHops; hop++ {
+		for _, intermediate := range conf.Intermediates {
+			if hops[intermediate] != hop-1 {
+				continue
+			}
+			via := rates[intermediate]
+
+			for _, market := range markets {
+				parts := strings.SplitN(market, "/", 2)
+				if len(parts) != 2 {
+					continue
+				}
+
+				var symbol string
+				inverted := false
+				switch intermediate {
+				case parts[1]:
+					symbol = parts[0]
+				case parts[0]:
+					symbol, inverted = parts[1], true
+				default:
+					continue
+				}
+				if _, exists := rates[symbol]; exists {
+					continue
+				}
+
+				quote := quotes[market]
+				rate, err := crossRate(via, quote, inverted)
+				if err != nil {
+					job.EventErrKv("cross.triangulate", err, health.Kvs{"market": market})
+					continue
+				}
+				rate.Type = exchangeRateTypeCrypto.String()
+				if _, ok := iso4217Currencies[symbol]; ok {
+					rate.Type = exchangeRateTypeFiat.String()
+				}
+				rate.Source = quote.Source
+				rate.Derived = true
+				rate.Path = append(ratePath(intermediate, via), quote.Source+":"+market)
+
+				rates[symbol] = rate
+				derived++
+				if containsString(conf.Intermediates, symbol) {
+					hops[symbol] = hop
+				}
+			}
+		}
+	}
+
+	job.EventKv("cross.triangulate", health.Kvs{"derived": strconv.Itoa(derived)})
+}
+
+// crossRate derives a symbol's rate from an intermediate's rate and a market
+// between them. The market is SYMBOL/INTERMEDIATE unless inverted, in which
+// case it is INTERMEDIATE/SYMBOL. Spreads are carried through so the ask stays
+// the cost of buying the base through the market.
+func crossRate(via exchangeRate, quote exchangeRate, inverted bool) (exchangeRate, error) {
+	viaPrices, err := parseRatePrices(via)
+	if err != nil {
+		return exchangeRate{}, err
+	}
+	quotePrices, err := parseRatePrices(quote)
+	if err != nil {
+		return exchangeRate{}, err
+	}
+
+	if inverted {
+		return exchangeRate{
+			Ask:  formatDerivedPrice(viaPrices[0] * quotePrices[0]),
+			Bid:  formatDerivedPrice(viaPrices[1] * quotePrices[1]),
+			Last: formatDerivedPrice(viaPrices[2] * quotePrices[2]),
+		}, nil
+	}
+
+	for _, price := range quotePrices {
+		if price <= 0 {
+			return exchangeRate{}, errInvalidCrossQuote("prices must be positive")
+		}
+	}
+	return exchangeRate{
+		Ask:  formatDerivedPrice(viaPrices[0] / quotePrices[1]),
+		Bid:  formatDerivedPrice(viaPrices[1] / quotePrices[0]),
+		Last: formatDerivedPrice(viaPrices[2] / quotePrices[2]),
+	}, nil
+}
+
+// ratePath returns the path of rates behind a symbol's rate: its own path if
+// it was derived, otherwise the market it was fetched as
+func ratePath(symbol string, rate exchangeRate) []string {
+	if rate.Derived {
+		return append([]string{}, rate.Path...)
+	}
+	return []string{rate.Source + ":BTC/" + symbol}
+}
+
+type errInvalidCrossQuote string
+
+func (e errInvalidCrossQuote) Error() string {
+	return "Invalid cross quote: " + string(e)
+}
@@ -0,0 +1,66 @@
+package ticker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gocraft/health"
+)
+
+func TestTriangulateCross(t *testing.T) {
+	job := health.NewStream().NewJob("fetch")
+	quotes := exchangeRates{
+		"FOO/ETH":  {Ask: "0.6", Bid: "0.4", Last: "0.5", Type: "cross", Source: "kraken"},
+		"ETH/BAR":  {Ask: "110", Bid: "90", Last: "100", Type: "cross", Source: "coinbase"},
+		"BAZ/USDT": {Ask: "2", Bid: "2", Last: "2", Type: "cross", Source: "kraken"},
+		"USDT/ETH": {Ask: "0.001", Bid: "0.001", Last: "0.001", Type: "cross", Source: "kraken"},
+		"QUX/USDT": {Ask: "4", Bid: "4", Last: "4", Type: "cross", Source: "kraken"},
+	}
+	newRates := func() exchangeRates {
+		return exchangeRates{
+			"ETH": {Ask: "30", Bid: "20", Last: "25", Type: "crypto", Source: "cmc"},
+			"QUX": {Ask: "1", Bid: "1", Last: "1", Type: "crypto", Source: "cmc"},
+		}
+	}
+
+	rates := newRates()
+	triangulateCross(job, CrossConfig{Intermediates: []string{"ETH", "USDT"}, MaxHops: 2}, rates, quotes)
+	expectedFOO := exchangeRate{
+		Ask:     "75",
+		Bid:     "33.333333333333336",
+		Last:    "50",
+		Type:    "crypto",
+		Source:  "kraken",
+		Path:    []string{"cmc:BTC/ETH", "kraken:FOO/ETH"},
+		Derived: true,
+	}
+	if !reflect.DeepEqual(rates["FOO"], expectedFOO) {
+		t.Fatal("Incorrect derived rate:", rates["FOO"])
+	}
+	if rates["BAR"].Ask != "3300" || rates["BAR"].Bid != "1800" || rates["BAR"].Last != "2500" {
+		t.Fatal("Incorrect rate derived from an inverted market:", rates["BAR"])
+	}
+	if rates["USDT"].Last != "25000" || len(rates["USDT"].Path) != 2 {
+		t.Fatal("Incorrect intermediate rate:", rates["USDT"])
+	}
+	if _, ok := rates["BAZ"]; ok {
+		t.Fatal("Derived a rate beyond the hop limit:", rates["BAZ"])
+	}
+	if rates["QUX"].Source != "cmc" {
+		t.Fatal("Replaced a fetched rate:", rates["QUX"])
+	}
+
+	rates = newRates()
+	triangulateCross(job, CrossConfig{Intermediates: []string{"ETH", "USDT"}, MaxHops: 3}, rates, quotes)
+	expectedPath := []string{"cmc:BTC/ETH", "kraken:USDT/ETH", "kraken:BAZ/USDT"}
+	if rates["BAZ"].Last != "12500" || !reflect.DeepEqual(rates["BAZ"].Path, expectedPath) {
+		t.Fatal("Incorrect rate derived through two intermediates:", rates["BAZ"])
+	}
+
+	// Only configured intermediates are used
+	rates = newRates()
+	triangulateCross(job, CrossConfig{Intermediates: []string{"USDT"}, MaxHops: 3}, rates, quotes)
+	if len(rates) != 2 {
+		t.Fatal("Derived rates through a symbol that isn't an intermediate:", rates)
+	}
+}
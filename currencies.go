@@ -0,0 +1,44 @@
+package ticker
+
+const (
+	// cryptoDisplayDecimals is the number of decimal places crypto amounts are
+	// displayed with
+	cryptoDisplayDecimals = 8
+
+	// defaultFiatDecimals is used for fiat symbols missing from the ISO 4217 table
+	defaultFiatDecimals = 2
+)
+
+// currencyMetadata describes a published symbol for display by clients
+type currencyMetadata struct {
+	Name     string `json:"name,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Decimals int    `json:"decimals"`
+}
+
+// buildCurrencies returns the metadata for each symbol in the given rates.
+// Fiat symbols are described by the ISO 4217 table and crypto symbols by the
+// names and IDs reported by their sources.
+func buildCurrencies(rates exchangeRates) map[string]currencyMetadata {
+	currencies := make(map[string]currencyMetadata, len(rates))
+	for symbol, rate := range rates {
+		if rate.Type == exchangeRateTypeFiat.String() {
+			metadata := currencyMetadata{Type: rate.Type, Decimals: defaultFiatDecimals}
+			if iso, ok := iso4217Currencies[symbol]; ok {
+				metadata.Name = iso.name
+				metadata.Decimals = iso.minorUnits
+			}
+			currencies[symbol] = metadata
+			continue
+		}
+
+		currencies[symbol] = currencyMetadata{
+			Name:     rate.Name,
+			ID:       rate.CMCID,
+			Type:     rate.Type,
+			Decimals: cryptoDisplayDecimals,
+		}
+	}
+	return currencies
+}
@@ -0,0 +1,30 @@
+package ticker
+
+import (
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+// RunDaemon fetches and writes rates on the configured interval until stop is
+// closed. Failed runs are reported to the stream and retried on the next tick.
+// The symbol policy is reloaded whenever it changes, and the exchange feeds are
+// read in the background when streams are configured.
+func RunDaemon(stream *health.Stream, conf Config, stop <-chan struct{}, writers ...NamedWriter) {
+	interval := conf.IntervalDuration()
+	go WatchSymbolPolicy(stream, conf, interval, stop)
+	StartStreams(stream, conf, stop)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Errors have already been emitted to the stream
+		FetchWithResult(stream, conf, writers...)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
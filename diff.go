@@ -0,0 +1,124 @@
+package ticker
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/gocraft/health"
+)
+
+// RateChange describes how the rate for a symbol differs between the published
+// api document and a fresh run
+type RateChange struct {
+	Symbol string      `json:"symbol"`
+	Old    json.Number `json:"old,omitempty"`
+	New    json.Number `json:"new,omitempty"`
+
+	// Change is the relative change of the last price, e.g. 0.05 for +5%. It
+	// is only set for changed symbols.
+	Change float64 `json:"change,omitempty"`
+}
+
+// RatesDiff describes what a run would change in the published api document
+type RatesDiff struct {
+	Added   []RateChange `json:"added"`
+	Removed []RateChange `json:"removed"`
+	Changed []RateChange `json:"changed"`
+
+	// Overridden maps symbols changed by overrides to the action and reason
+	Overridden map[string]string `json:"overridden,omitempty"`
+
+	// Filtered maps symbols removed by the market floors to the reason
+	Filtered map[string]string `json:"filtered,omitempty"`
+
+	// Dropped maps symbols removed by validation rules to the reason
+	Dropped map[string]string `json:"dropped"`
+}
+
+// Diff runs the full fetch, merge and validate pipeline without writing
+// anything and compares the result against the currently published api
+// document. If validation fails the diff is returned along with the error.
+func Diff(stream *health.Stream, conf Config) (*RatesDiff, error) {
+	job := stream.NewJob("diff")
+
+	publishedRates, err := loadPublishedRates(conf)
+	if err != nil {
+		job.EventErr("load_published_rates", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	history, err := loadSmoothingHistory(conf)
+	if err != nil {
+		job.EventErr("load_rate_history", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	overrides, err := LoadOverrides(conf)
+	if err != nil {
+		job.EventErr("load_overrides", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	status := &Status{}
+	rates, err := collectRates(job, conf, publishedRates, history, overrides, status)
+	if rates == nil {
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	rates = filterRates(rates, conf.Symbols)
+	diff := diffRates(publishedRates, rates)
+	diff.Overridden = status.Overridden
+	diff.Filtered = status.Filtered
+	diff.Dropped = status.Dropped
+	if err != nil {
+		job.Complete(health.ValidationError)
+		return diff, err
+	}
+
+	job.Complete(health.Success)
+	return diff, nil
+}
+
+func diffRates(oldRates exchangeRates, newRates exchangeRates) *RatesDiff {
+	diff := &RatesDiff{
+		Added:   []RateChange{},
+		Removed: []RateChange{},
+		Changed: []RateChange{},
+	}
+
+	for symbol, newRate := range newRates {
+		oldRate, ok := oldRates[symbol]
+		if !ok {
+			diff.Added = append(diff.Added, RateChange{Symbol: symbol, New: newRate.Last})
+			continue
+		}
+
+		if oldRate.Ask == newRate.Ask && oldRate.Bid == newRate.Bid && oldRate.Last == newRate.Last {
+			continue
+		}
+
+		change := RateChange{Symbol: symbol, Old: oldRate.Last, New: newRate.Last}
+		oldLast, oldErr := oldRate.Last.Float64()
+		newLast, newErr := newRate.Last.Float64()
+		if oldErr == nil && newErr == nil && oldLast != 0 {
+			change.Change = (newLast - oldLast) / oldLast
+		}
+		diff.Changed = append(diff.Changed, change)
+	}
+
+	for symbol, oldRate := range oldRates {
+		if _, ok := newRates[symbol]; !ok {
+			diff.Removed = append(diff.Removed, RateChange{Symbol: symbol, Old: oldRate.Last})
+		}
+	}
+
+	for _, changes := range [][]RateChange{diff.Added, diff.Removed, diff.Changed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Symbol < changes[j].Symbol })
+	}
+
+	return diff
+}
@@ -0,0 +1,68 @@
+package ticker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gocraft/health"
+)
+
+func TestDiffRates(t *testing.T) {
+	diff := diffRates(exchangeRates{
+		"BTC": {Ask: "1", Bid: "1", Last: "1"},
+		"USD": {Ask: "6500", Bid: "6400", Last: "6400"},
+		"EUR": {Ask: "5600", Bid: "5500", Last: "5550"},
+		"OLD": {Ask: "2", Bid: "2", Last: "2"},
+	}, exchangeRates{
+		"BTC": {Ask: "1", Bid: "1", Last: "1"},
+		"USD": {Ask: "6600", Bid: "6500", Last: "6560"},
+		"EUR": {Ask: "5610", Bid: "5500", Last: "5550"},
+		"NEW": {Ask: "3", Bid: "3", Last: "3"},
+	})
+
+	expected := &RatesDiff{
+		Added:   []RateChange{{Symbol: "NEW", New: "3"}},
+		Removed: []RateChange{{Symbol: "OLD", Old: "2"}},
+		Changed: []RateChange{
+			{Symbol: "EUR", Old: "5550", New: "5550"},
+			{Symbol: "USD", Old: "6400", New: "6560", Change: 0.025},
+		},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("Incorrect diff\nGot: %+v\nWanted: %+v", diff, expected)
+	}
+}
+
+func TestDiffFiltersSymbols(t *testing.T) {
+	disableMocksFn := createHTTPMocks()
+	defer disableMocksFn()
+
+	err := SetSymbolPolicy(SymbolPolicy{Pinned: DefaultSymbolPolicy().Pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGPubkey = "pubkey"
+	conf.BTCAVGPrivkey = "privkey"
+	conf.Providers = []string{"btcavg"}
+	conf.Symbols = []string{"BTC", "USD"}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is published so every symbol a run would write is added
+	diff, err := Diff(health.NewStream(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := []string{}
+	for _, change := range diff.Added {
+		added = append(added, change.Symbol)
+	}
+	if !reflect.DeepEqual(added, []string{"BTC", "USD"}) {
+		t.Fatal("Expected only the configured symbols to be added, got:", added)
+	}
+}
@@ -0,0 +1,94 @@
+package ticker
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+const (
+	ecbDefaultBaseURL = "https://www.ecb.europa.eu"
+	ecbDailyPath      = "/stats/eurofxref/eurofxref-daily.xml"
+
+	// ecbMaxAge is how old the reference rates may be before a warning is
+	// emitted. They aren't published on weekends and TARGET holidays.
+	ecbMaxAge = 5 * 24 * time.Hour
+)
+
+// ecbEnvelope is the eurofxref XML document
+type ecbEnvelope struct {
+	Cube struct {
+		Cube []struct {
+			Time string `xml:"time,attr"`
+			Cube []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+// NewECBFetcher returns the ECB euro foreign exchange reference rates as an
+// FX table: the units of each currency per EUR, with a Type of fx
+func NewECBFetcher(baseURL string) fetchFn {
+	return func(job *health.Job, client *http.Client) (exchangeRates, error) {
+		req, err := http.NewRequest("GET", baseURL+ecbDailyPath, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errUnexpectedStatus{"ecb", resp.StatusCode}
+		}
+
+		envelope := &ecbEnvelope{}
+		err = xml.Unmarshal(body, envelope)
+		if err != nil {
+			return nil, err
+		}
+		if len(envelope.Cube.Cube) == 0 {
+			return nil, errInvalidFXTable("no reference rates in the ECB response")
+		}
+
+		// The daily document has a single day of rates
+		day := envelope.Cube.Cube[0]
+		if date, err := time.Parse("2006-01-02", day.Time); err != nil || time.Since(date) > ecbMaxAge {
+			job.EventKv("warn.stale_fx", health.Kvs{"provider": "ecb", "date": day.Time})
+		}
+
+		table := exchangeRates{"EUR": newFXRate("1")}
+		for _, entry := range day.Cube {
+			if _, err := strconv.ParseFloat(entry.Rate, 64); err != nil {
+				return nil, errInvalidFXTable("invalid rate for " + entry.Currency + ": " + entry.Rate)
+			}
+			table[entry.Currency] = newFXRate(json.Number(entry.Rate))
+		}
+		return table, nil
+	}
+}
+
+func newFXRate(rate json.Number) exchangeRate {
+	return exchangeRate{Ask: rate, Bid: rate, Last: rate, Type: exchangeRateTypeFX.String()}
+}
+
+type errInvalidFXTable string
+
+func (e errInvalidFXTable) Error() string {
+	return "Invalid FX table: " + string(e)
+}
@@ -0,0 +1,110 @@
+package ticker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestParseKrakenPair(t *testing.T) {
+	for pair, expected := range map[string][2]string{
+		"XXBTZUSD": {"BTC", "USD"},
+		"XBTUSD":   {"BTC", "USD"},
+		"XETHXXBT": {"ETH", "BTC"},
+		"BCHXBT":   {"BCH", "BTC"},
+		"DASHXBT":  {"DASH", "BTC"},
+		"XXDGXXBT": {"DOGE", "BTC"},
+		"XBTCHF":   {"BTC", "CHF"},
+		"XBTUSDT":  {"BTC", "USDT"},
+		"XTZUSD":   {"XTZ", "USD"},
+		"XTZXBT":   {"XTZ", "BTC"},
+	} {
+		base, quote, ok := parseKrakenPair(pair)
+		if !ok || base != expected[0] || quote != expected[1] {
+			t.Fatalf("Incorrect pair for %s: %s/%s", pair, base, quote)
+		}
+	}
+
+	if _, _, ok := parseKrakenPair("XBT"); ok {
+		t.Fatal("Expected a lone asset not to parse")
+	}
+}
+
+func TestPairRate(t *testing.T) {
+	symbol, rate, ok, err := pairRate("BTC", "USD", "10010", "9990", "10000")
+	if err != nil || !ok || symbol != "USD" || rate.Ask != "10010" || rate.Bid != "9990" || rate.Type != "fiat" {
+		t.Fatal("Incorrect fiat rate:", symbol, rate, err)
+	}
+
+	// Inverting a BTC quoted market swaps the ask and bid
+	symbol, rate, ok, err = pairRate("ETH", "BTC", "0.05", "0.04", "0.045")
+	if err != nil || !ok || symbol != "ETH" || rate.Ask != "25" || rate.Bid != "20" || rate.Type != "crypto" {
+		t.Fatal("Incorrect crypto rate:", symbol, rate, err)
+	}
+
+	// Markets without BTC are cross quotes
+	symbol, rate, ok, err = pairRate("ETH", "USD", "1", "1", "1")
+	if err != nil || !ok || symbol != "ETH/USD" || rate.Type != "cross" {
+		t.Fatal("Incorrect cross quote:", symbol, rate, err)
+	}
+	if _, _, ok, _ := pairRate("BTC", "EUR", "", "1", "1"); ok {
+		t.Fatal("Expected a ticker without prices to be skipped")
+	}
+}
+
+func TestFetchExchanges(t *testing.T) {
+	server := httptest.NewServer(fakeprovider.New(1, 0))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.KrakenBaseURL = server.URL
+	conf.CoinbaseBaseURL = server.URL
+	conf.Providers = []string{"btcavg", "kraken", "coinbase"}
+	conf.KrakenPairs = []string{"XBTUSD", "XBTEUR", "ETHXBT", "XDGXBT"}
+	conf.CoinbaseProducts = []string{"BTC-GBP", "LTC-BTC", "ETH-USD"}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := NewMemoryWriter()
+	err := Fetch(health.NewStream(), conf, writer.Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(writer.artifacts["api_v2"].Data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for symbol, source := range map[string]string{
+		"USD":  "kraken",
+		"EUR":  "kraken",
+		"ETH":  "kraken",
+		"DOGE": "kraken",
+		"GBP":  "coinbase",
+		"LTC":  "coinbase",
+		"XMR":  "btcavg",
+	} {
+		if extended[symbol].Source != source {
+			t.Fatalf("Expected %s from %s, got %s", symbol, source, extended[symbol].Source)
+		}
+	}
+
+	usd := extended["USD"]
+	ask, _ := usd.Ask.Float64()
+	bid, _ := usd.Bid.Float64()
+	if ask <= bid {
+		t.Fatal("Expected a spread between the ask and bid:", usd)
+	}
+
+	conf.KrakenPairs = []string{"BOGUS"}
+	if _, ok := conf.Validate().(errInvalidConfig); !ok {
+		t.Fatal("Expected an unsupported kraken pair to be invalid")
+	}
+}
@@ -0,0 +1,54 @@
+package ticker
+
+import "encoding/json"
+
+// extendedRate is a rate in the api_v2 document, which extends the api
+// document with where each rate came from
+type extendedRate struct {
+	Ask  json.Number `json:"ask"`
+	Bid  json.Number `json:"bid"`
+	Last json.Number `json:"last"`
+	Type string      `json:"type"`
+
+	// Source is the provider the rate came from
+	Source string `json:"source"`
+
+	// Path lists the rates a derived rate was computed from, each as
+	// source:BASE/QUOTE
+	Path []string `json:"path,omitempty"`
+
+	// Derived is set for rates computed from other rates rather than fetched
+	Derived bool `json:"derived,omitempty"`
+
+	// Smoothing is the method the prices were smoothed with, and Raw the
+	// prices before smoothing
+	Smoothing string     `json:"smoothing,omitempty"`
+	Raw       *rawPrices `json:"raw,omitempty"`
+
+	// Market describes the coin's market, when a provider reports it
+	Market *marketData `json:"market,omitempty"`
+
+	// Override is set for rates fixed or frozen by an override
+	Override *rateOverride `json:"override,omitempty"`
+}
+
+// buildExtendedRates returns the api_v2 document for the given rates
+func buildExtendedRates(rates exchangeRates) map[string]extendedRate {
+	extended := make(map[string]extendedRate, len(rates))
+	for symbol, rate := range rates {
+		extended[symbol] = extendedRate{
+			Ask:       rate.Ask,
+			Bid:       rate.Bid,
+			Last:      rate.Last,
+			Type:      rate.Type,
+			Source:    rate.Source,
+			Path:      rate.Path,
+			Derived:   rate.Derived,
+			Smoothing: rate.Smoothing,
+			Raw:       rate.Raw,
+			Market:    rate.Market,
+			Override:  rate.Override,
+		}
+	}
+	return extended
+}
@@ -0,0 +1,107 @@
+package ticker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestFakeProvider(t *testing.T) {
+	provider := fakeprovider.New(1, 12)
+	provider.BTCAVGPubkey = "pubkey"
+	provider.BTCAVGPrivkey = "privkey"
+	provider.CMCAPIKey = "cmc-api-key"
+	provider.Delay = time.Millisecond
+	server := httptest.NewServer(provider)
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.CMCBaseURL = server.URL
+	conf.BTCAVGPubkey = "pubkey"
+	conf.BTCAVGPrivkey = "privkey"
+	conf.CMCAPIKey = "cmc-api-key"
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func() (exchangeRates, error) {
+		writer := NewMemoryWriter()
+		err := Fetch(health.NewStream(), conf, writer.Write)
+		if err != nil {
+			return nil, err
+		}
+		rates := exchangeRates{}
+		err = json.Unmarshal(writer.artifacts["api"].Data, &rates)
+		return rates, err
+	}
+
+	rates, err := fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, symbol := range []string{"USD", "EUR", "ETH", "XMR", "FAKE12"} {
+		if _, ok := rates[symbol]; !ok {
+			t.Fatal("Missing symbol:", symbol)
+		}
+	}
+
+	err = provider.SetScenarios(fakeprovider.ScenarioNullPrices, fakeprovider.ScenarioRandomWalk, fakeprovider.ScenarioSlow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkedRates, err := fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := walkedRates["XMR"]; ok {
+		t.Fatal("Expected null priced XMR to be left out")
+	}
+	if walkedRates["USD"].Last == rates["USD"].Last {
+		t.Fatal("Expected prices to move")
+	}
+
+	// Misbehaving providers fail the run
+	for scenario, expected := range map[string]func(error) bool{
+		fakeprovider.ScenarioRateLimit: func(err error) bool {
+			return err == errUnexpectedStatus{"btcavg", http.StatusTooManyRequests}
+		},
+		fakeprovider.ScenarioMalformedJSON: func(err error) bool {
+			_, ok := err.(*json.SyntaxError)
+			return ok
+		},
+	} {
+		err = provider.SetScenarios(scenario)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fetch(); !expected(err) {
+			t.Fatal("Incorrect error for scenario", scenario, err)
+		}
+	}
+
+	// Credentials are checked
+	err = provider.SetScenarios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.CMCAPIKey = "wrong-key"
+	if _, err = fetch(); err != (errUnexpectedStatus{"cmc", http.StatusUnauthorized}) {
+		t.Fatal("Expected unauthorized CMC error, got:", err)
+	}
+	conf.CMCAPIKey = "cmc-api-key"
+	conf.BTCAVGPrivkey = "wrong-privkey"
+	if _, err = fetch(); err != (errUnexpectedStatus{"btcavg", http.StatusUnauthorized}) {
+		t.Fatal("Expected unauthorized BTCAVG error, got:", err)
+	}
+
+	if provider.SetScenarios("unknown") == nil {
+		t.Fatal("Expected unknown scenario to be rejected")
+	}
+}
@@ -2,6 +2,7 @@ package ticker
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -10,61 +11,453 @@ import (
 
 var httpClient = &http.Client{Timeout: 30 * time.Second}
 
-type fetchFn func() (exchangeRates, error)
+// fetchFn fetches rates from a provider, making requests with the given client
+type fetchFn func(job *health.Job, client *http.Client) (exchangeRates, error)
+
+// FetchResult summarizes a run
+type FetchResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+
+	// Symbols is the number of published symbols by type
+	Symbols map[string]int `json:"symbols"`
+
+	// Filtered is the number of symbols removed by the market floors
+	Filtered int `json:"filtered"`
+
+	// Dropped is the number of symbols removed by validation rules
+	Dropped int `json:"dropped"`
+
+	Providers []ProviderStatus `json:"providers"`
+	Writers   []WriterResult   `json:"writers"`
+}
+
+// WriterResult describes how writing the artifacts with a single Writer went
+type WriterResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
 
 // Fetch gets data from all sources, formats it, and sends it to the Writers.
+// A status artifact describing the run is always written; when the run fails
+// it is the only artifact written.
 func Fetch(stream *health.Stream, conf Config, writers ...Writer) error {
+	namedWriters := make([]NamedWriter, 0, len(writers))
+	for i, writer := range writers {
+		namedWriters = append(namedWriters, NamedWriter{Name: fmt.Sprintf("writer_%d", i), Writer: writer})
+	}
+	_, err := FetchWithResult(stream, conf, namedWriters...)
+	return err
+}
+
+// FetchWithResult is Fetch for named Writers that also returns a summary of
+// the run. Every Writer is tried even if an earlier one fails, and the first
+// error is returned.
+func FetchWithResult(stream *health.Stream, conf Config, writers ...NamedWriter) (*FetchResult, error) {
 	job := stream.NewJob("fetch")
+	status := newStatus(job, conf)
+	result := &FetchResult{Symbols: map[string]int{}, Writers: []WriterResult{}}
+
+	// Load the overrides every run so they apply without a restart
+	overrides, err := LoadOverrides(conf)
+	if err != nil {
+		job.EventErr("load_overrides", err)
+		return failFetch(job, status, result, err, writers)
+	}
 
-	// Fetch data from each provider
-	allRates := []exchangeRates{{"BTC": {Ask: "1", Bid: "1", Last: "1", Type: exchangeRateTypeCrypto.String()}}}
-	for _, f := range []fetchFn{
-		NewBTCAVGFetcher(conf.BTCAVGPubkey, conf.BTCAVGPrivkey),
-		NewCMCFetcher(conf.CMCEnv, conf.CMCAPIKey),
-	} {
-		rates, err := f()
+	// Load the last published rates for rules that compare against them and
+	// frozen symbols
+	var previousRates exchangeRates
+	if hasRuleType(conf.ValidationRules, RuleMaxChange) || hasOverrideAction(overrides, OverrideFreeze) {
+		previousRates, err = loadPublishedRates(conf)
 		if err != nil {
-			job.EventErr("fetch_data", err)
-			job.Complete(health.Error)
-			return err
+			job.EventErr("load_published_rates", err)
+			return failFetch(job, status, result, err, writers)
 		}
-		allRates = append(allRates, rates)
 	}
 
-	fullRates := mergeRates(allRates)
+	// Load the history of recent runs for smoothing
+	history, err := loadSmoothingHistory(conf)
+	if err != nil {
+		job.EventErr("load_rate_history", err)
+		return failFetch(job, status, result, err, writers)
+	}
 
-	// Ensure the final payload passes correctness checks
-	err := validateRates(fullRates)
+	fullRates, err := collectRates(job, conf, previousRates, history, overrides, status)
+	result.Providers = status.Providers
+	result.Filtered = len(status.Filtered)
+	result.Dropped = len(status.Dropped)
 	if err != nil {
-		job.EventErr("validate_rates", err)
-		job.Complete(health.Error)
-		return err
+		return failFetch(job, status, result, err, writers)
+	}
+
+	fullRates = filterRates(fullRates, conf.Symbols)
+	result.Symbols = countRatesByType(fullRates)
+	for rateType, count := range result.Symbols {
+		job.GaugeKv("symbols", float64(count), health.Kvs{"type": rateType})
 	}
 
 	// Serialize responses
 	responseBytes, err := json.Marshal(fullRates)
 	if err != nil {
 		job.EventErr("marshal", err)
-		job.Complete(health.Error)
-		return err
+		return failFetch(job, status, result, err, writers)
+	}
+
+	currenciesBytes, err := json.Marshal(buildCurrencies(fullRates))
+	if err != nil {
+		job.EventErr("marshal", err)
+		return failFetch(job, status, result, err, writers)
+	}
+
+	extendedBytes, err := json.Marshal(buildExtendedRates(fullRates))
+	if err != nil {
+		job.EventErr("marshal", err)
+		return failFetch(job, status, result, err, writers)
+	}
+
+	artifacts := []Artifact{
+		{Name: "api", Data: responseBytes},
+		{Name: "whitelist", Data: PinnedSymbolsToIDsJSON()},
+		{Name: "currencies", Data: currenciesBytes},
+		{Name: "api_v2", Data: extendedBytes},
+	}
+	if history != nil {
+		historyArtifact, err := history.artifact()
+		if err != nil {
+			job.EventErr("marshal", err)
+			return failFetch(job, status, result, err, writers)
+		}
+		artifacts = append(artifacts, historyArtifact)
 	}
 
 	// Write
+	err = publishArtifacts(job, result, status, artifacts, writers)
+	if err != nil {
+		result.Error = err.Error()
+		job.Complete(health.Error)
+		return result, err
+	}
+
+	result.OK = true
+	job.Complete(health.Success)
+	return result, nil
+}
+
+// failFetch publishes the status of a failed run and completes the job. The
+// original error is returned; errors writing the status are only reported.
+func failFetch(job *health.Job, status *Status, result *FetchResult, err error, writers []NamedWriter) (*FetchResult, error) {
+	result.Error = err.Error()
+	status.fail(err)
+	statusArtifact, marshalErr := status.artifact()
+	if marshalErr != nil {
+		job.EventErr("marshal", marshalErr)
+		job.Complete(health.Error)
+		return result, err
+	}
+
+	writeArtifacts(job, result, "write_status", []Artifact{statusArtifact}, writers)
+	job.Complete(health.Error)
+	return result, err
+}
+
+// publishArtifacts sends the artifacts and then the status to every Writer,
+// recording the outcome of each in the result. The status is only marked
+// successful for a Writer once it has taken every artifact; Writers that
+// failed are sent the status of a failed run instead. Errors are emitted and
+// the first one is returned.
+func publishArtifacts(job *health.Job, result *FetchResult, status *Status, artifacts []Artifact, writers []NamedWriter) error {
+	var firstErr error
+	for _, writer := range writers {
+		kvs := health.Kvs{"writer": writer.Name}
+		writerStatus := *status
+		err := writer.Writer(job, artifacts)
+		if err != nil {
+			job.EventErrKv("write", err, kvs)
+			writerStatus.fail(err)
+		} else {
+			writerStatus.succeed()
+		}
+
+		statusArtifact, statusErr := writerStatus.artifact()
+		if statusErr == nil {
+			statusErr = writer.Writer(job, []Artifact{statusArtifact})
+		}
+		if statusErr != nil {
+			job.EventErrKv("write_status", statusErr, kvs)
+			if err == nil {
+				err = statusErr
+			}
+		}
+
+		writerResult := WriterResult{Name: writer.Name, OK: true}
+		if err != nil {
+			writerResult = WriterResult{Name: writer.Name, Error: err.Error()}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		result.Writers = append(result.Writers, writerResult)
+	}
+	return firstErr
+}
+
+// writeArtifacts sends the artifacts to every Writer, recording the outcome of
+// each in the result. Errors are emitted under the given event and the first
+// one is returned.
+func writeArtifacts(job *health.Job, result *FetchResult, event string, artifacts []Artifact, writers []NamedWriter) error {
+	var firstErr error
 	for _, writer := range writers {
-		err := writer(job, responseBytes)
+		writerResult := WriterResult{Name: writer.Name, OK: true}
+		err := writer.Writer(job, artifacts)
 		if err != nil {
-			job.EventErr("write", err)
-			job.Complete(health.Error)
-			return err
+			job.EventErrKv(event, err, health.Kvs{"writer": writer.Name})
+			writerResult = WriterResult{Name: writer.Name, Error: err.Error()}
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
+		result.Writers = append(result.Writers, writerResult)
 	}
+	return firstErr
+}
 
-	job.Complete(health.Success)
-	return nil
+// filterRates returns only the rates for the given symbols. All rates are
+// returned when no symbols are given.
+func filterRates(rates exchangeRates, symbols []string) exchangeRates {
+	if len(symbols) == 0 {
+		return rates
+	}
+
+	filtered := exchangeRates{}
+	for _, symbol := range symbols {
+		symbol = CanonicalizeSymbol(symbol)
+		if rate, ok := rates[symbol]; ok {
+			filtered[symbol] = rate
+		}
+	}
+	return filtered
+}
+
+// provider is a named source of rates
+type provider struct {
+	name  string
+	fetch fetchFn
+
+	// enabled reports whether the provider is configured to be fetched. Nil
+	// means always.
+	enabled func(Config) bool
+}
+
+// newProviders returns the providers to fetch rates from, in the order their
+// rates are merged. Only the providers named in the config are returned when
+// any are named.
+func newProviders(conf Config) []provider {
+	providers := []provider{}
+	for _, p := range allProviders(conf) {
+		if p.enabled != nil && !p.enabled(conf) {
+			continue
+		}
+		if len(conf.Providers) == 0 || containsString(conf.Providers, p.name) {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// allProviders returns every provider in the order their rates are merged
+func allProviders(conf Config) []provider {
+	return []provider{
+		{name: "btcavg", fetch: NewBTCAVGFetcher(conf.BTCAVGBaseURLOrDefault(), conf.AllBTCAVGKeys()...)},
+		{name: "cmc", fetch: newCMCFetcherForMode(conf)},
+		{name: "kraken", fetch: NewKrakenFetcher(conf.KrakenBaseURLOrDefault(), conf.KrakenPairs), enabled: func(c Config) bool { return len(c.KrakenPairs) > 0 }},
+		{name: "coinbase", fetch: NewCoinbaseFetcher(conf.CoinbaseBaseURLOrDefault(), conf.CoinbaseProducts), enabled: func(c Config) bool { return len(c.CoinbaseProducts) > 0 }},
+		{name: "stream", fetch: NewStreamFetcher(conf.Stream.maxAge()), enabled: func(c Config) bool { return c.Stream.enabled() && streamsRunning() }},
+		{name: "ecb", fetch: NewECBFetcher(conf.ECBBaseURLOrDefault()), enabled: func(c Config) bool { return c.FX.enabled() }},
+	}
+}
+
+// newCMCFetcherForMode creates the CMC fetcher for the configured mode
+func newCMCFetcherForMode(conf Config) fetchFn {
+	if conf.CMCMode == CMCModeQuotes {
+		return NewCMCQuotesFetcher(conf.CMCBaseURLOrDefault(), conf.CMCIDs, conf.AllCMCAPIKeys()...)
+	}
+	return NewCMCFetcher(conf.CMCBaseURLOrDefault(), conf.AllCMCAPIKeys()...)
+}
+
+// isProviderName checks if a provider has the given name
+func isProviderName(name string) bool {
+	for _, p := range allProviders(Config{}) {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// providerRates are the rates fetched from a single provider
+type providerRates struct {
+	provider string
+	rates    exchangeRates
+}
+
+// fetchProviders fetches data from each provider. Every provider is tried so
+// the status of each is known, but the first error of a provider that isn't
+// optional is returned. Failed optional providers are left out and the others
+// fill in what they can, e.g. ECB triangulation covers fiat when BTCAVG is
+// down. An error is also returned when every provider fails.
+func fetchProviders(job *health.Job, conf Config) ([]providerRates, []ProviderStatus, error) {
+	var firstErr, optionalErr error
+	fetched := []providerRates{}
+	statuses := []ProviderStatus{}
+	for _, p := range newProviders(conf) {
+		kvs := health.Kvs{"provider": p.name}
+		start := time.Now()
+		rates, err := p.fetch(job, newProviderClient(job, conf, p.name))
+		latency := time.Since(start)
+		job.TimingKv("fetch_provider", latency.Nanoseconds(), kvs)
+
+		status := ProviderStatus{Name: p.name, LatencyMS: int64(latency / time.Millisecond)}
+		if err != nil {
+			job.EventErrKv("fetch_data", err, kvs)
+			status.Error = err.Error()
+			statuses = append(statuses, status)
+			if containsString(conf.OptionalProviders, p.name) {
+				if optionalErr == nil {
+					optionalErr = err
+				}
+			} else if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		status.OK = true
+		status.Symbols = len(rates)
+		statuses = append(statuses, status)
+		fetched = append(fetched, providerRates{p.name, rates})
+	}
+
+	if firstErr == nil && len(fetched) == 0 {
+		firstErr = optionalErr
+	}
+	if firstErr != nil {
+		return nil, statuses, firstErr
+	}
+	return fetched, statuses, nil
+}
+
+// collectRates fetches data from all sources, merges it and ensures it passes
+// the validation rules. The previous rates are the last published snapshot and
+// may be nil, as may the history, which is only needed for smoothing. If
+// validation fails the validated rates are returned along with the error; if
+// fetching fails no rates are returned. The outcome of each step is recorded in
+// the status.
+func collectRates(job *health.Job, conf Config, previousRates exchangeRates, history *rateHistory, overrides []Override, status *Status) (exchangeRates, error) {
+	fetched, providerStatuses, err := fetchProviders(job, conf)
+	status.Providers = providerStatuses
+	if err != nil {
+		return nil, err
+	}
+
+	rates, report, err := mergeAndValidateRates(job, conf, fetched, previousRates, history, overrides)
+	status.ValidationReport = report
+	status.setRates(rates)
+	return rates, err
+}
+
+// mergeAndValidateRates merges the fetched rates, derives missing rates,
+// smooths them, applies the overrides and ensures they pass the validation
+// rules. Each rate is tagged with the provider it came from. The run is
+// recorded in the history when one is given. Frozen symbols take the previous
+// rates.
+func mergeAndValidateRates(job *health.Job, conf Config, fetched []providerRates, previousRates exchangeRates, history *rateHistory, overrides []Override) (exchangeRates, ValidationReport, error) {
+	allRates := []exchangeRates{{"BTC": {Ask: "1", Bid: "1", Last: "1", Type: exchangeRateTypeCrypto.String(), Name: "Bitcoin", CMCID: 1, Source: staticRateSource}}}
+	fxTables := []providerRates{}
+	crossQuotes := exchangeRates{}
+	for _, f := range fetched {
+		rates := exchangeRates{}
+		table := exchangeRates{}
+		for symbol, rate := range f.rates {
+			rate.Source = f.provider
+			switch rate.Type {
+			case exchangeRateTypeFX.String():
+				table[symbol] = rate
+			case exchangeRateTypeCross.String():
+				crossQuotes[symbol] = rate
+			default:
+				rates[symbol] = rate
+			}
+		}
+		allRates = append(allRates, rates)
+		if len(table) > 0 {
+			fxTables = append(fxTables, providerRates{f.provider, table})
+		}
+	}
+
+	fullRates := mergeRates(allRates)
+
+	if conf.Cross.enabled() {
+		triangulateCross(job, conf.Cross, fullRates, crossQuotes)
+	}
+
+	if conf.FX.enabled() {
+		if len(fxTables) == 0 {
+			job.EventErr("fx.triangulate", errInvalidFXTable("no FX table was fetched"))
+		}
+		for _, table := range fxTables {
+			triangulateFiat(job, conf.FX, fullRates, table.rates, table.provider)
+		}
+	}
+
+	if len(conf.Smoothing) > 0 && history != nil {
+		smoothRates(job, conf.Smoothing, fullRates, history, time.Now().UTC())
+	}
+
+	priceMarketData(fullRates)
+	overridden := applyOverrides(job, overrides, fullRates, previousRates, time.Now().UTC())
+	filtered := applyMarketFloors(job, conf.MarketFloors, fullRates)
+
+	// Ensure the final payload passes correctness checks
+	report, err := applyValidationRules(job, conf.ValidationRules, fullRates, previousRates)
+	if len(overridden) > 0 {
+		report.Overridden = overridden
+	}
+	if len(filtered) > 0 {
+		report.Filtered = filtered
+	}
+	if err == nil {
+		err = validateRates(fullRates)
+	}
+	if err != nil {
+		job.EventErr("validate_rates", err)
+		return fullRates, report, err
+	}
+
+	return fullRates, report, nil
+}
+
+// countRatesByType returns the number of rates of each type
+func countRatesByType(rates exchangeRates) map[string]int {
+	counts := map[string]int{}
+	for _, rate := range rates {
+		counts[rate.Type]++
+	}
+	return counts
 }
 
 func validateRates(rates exchangeRates) error {
-	for _, symbol := range RequiredSymbols {
+	for _, symbol := range CurrentSymbolPolicy().Required() {
 		if _, ok := rates[symbol]; !ok {
 			return errFetchMissingRequiredSymbol(symbol)
 		}
@@ -78,3 +471,21 @@ type errFetchMissingRequiredSymbol string
 func (e errFetchMissingRequiredSymbol) Error() string {
 	return "Missing required symbol: " + string(e)
 }
+
+type errUnexpectedStatus struct {
+	source string
+	status int
+}
+
+func (e errUnexpectedStatus) Error() string {
+	return fmt.Sprintf("Unexpected response status from %s: %d", e.source, e.status)
+}
+
+type errProviderResponse struct {
+	source  string
+	message string
+}
+
+func (e errProviderResponse) Error() string {
+	return fmt.Sprintf("Error response from %s: %s", e.source, e.message)
+}
@@ -1,11 +1,14 @@
 package ticker
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 	"time"
 
@@ -20,7 +23,7 @@ func init() {
 
 func TestFetch(t *testing.T) {
 	stream := health.NewStream()
-	stream.AddSink(&health.WriterSink{os.Stdout})
+	stream.AddSink(&health.WriterSink{Writer: os.Stdout})
 
 	disableMocksFn := createHTTPMocks()
 	defer disableMocksFn()
@@ -37,6 +40,7 @@ func TestFetch(t *testing.T) {
 	}
 
 	conf := Config{
+		OutPath:       outfilePath,
 		BTCAVGPubkey:  "pubkey",
 		BTCAVGPrivkey: "privkey",
 		CMCAPIKey:     "cmc-api-key",
@@ -44,19 +48,44 @@ func TestFetch(t *testing.T) {
 	}
 
 	// Fetch data. First let it fail with missing symbol, then override to let it
-	// work on a second run.
-	err = Fetch(stream, conf, func(_ *health.Job, data []byte) error {
-		if string(data) != testExpectedFetchData {
-			t.Fatal("Fetch returned incorrect data\nGot:", string(data), "\nWanted:", testExpectedFetchData)
+	// work on a second run. Failed runs only write their status.
+	err = Fetch(stream, conf, func(_ *health.Job, artifacts []Artifact) error {
+		if len(artifacts) != 1 || artifacts[0].Name != "status" {
+			t.Fatal("Failed run wrote more than its status:", len(artifacts))
 		}
 		return nil
 	}, NewFileSystemWriter(outfilePath))
 	if err != errFetchMissingRequiredSymbol("EUR") {
 		t.Fatal(err)
 	}
+	status := readTestStatus(t, outfilePath)
+	if status.OK || status.Error != err.Error() || status.LastSuccessAt != nil || len(status.Missing) == 0 {
+		t.Fatal("Incorrect failed status:", status)
+	}
+	if status.RequiredSources["BTC"] != staticRateSource || status.RequiredSources["USD"] != "btcavg" {
+		t.Fatal("Incorrect required sources:", status.RequiredSources)
+	}
+	for _, provider := range status.Providers {
+		if !provider.OK || provider.Symbols == 0 {
+			t.Fatal("Incorrect provider status:", provider)
+		}
+	}
+
+	err = SetSymbolPolicy(SymbolPolicy{
+		Pinned:  DefaultSymbolPolicy().Pinned,
+		Aliases: DefaultSymbolPolicy().Aliases,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
 
-	RequiredSymbols = []string{}
-	err = Fetch(stream, conf, func(_ *health.Job, data []byte) error {
+	err = Fetch(stream, conf, func(_ *health.Job, artifacts []Artifact) error {
+		// The status is written on its own once the rates are
+		if artifacts[0].Name == "status" {
+			return nil
+		}
+		data := testArtifactData(t, artifacts, "api")
 		if string(data) != testExpectedFetchData {
 			t.Fatal("Fetch returned incorrect data\nGot:", string(data), "\nWanted:", testExpectedFetchData)
 		}
@@ -67,7 +96,7 @@ func TestFetch(t *testing.T) {
 	}
 
 	// Make sure we wrote to outfiles
-	savedBytes, err := ioutil.ReadFile(path.Join(outfilePath, "rates"))
+	savedBytes, err := ioutil.ReadFile(path.Join(outfilePath, "api"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,6 +110,174 @@ func TestFetch(t *testing.T) {
 	if string(savedBytes) != string(PinnedSymbolsToIDsJSON()) {
 		t.Fatal("Incorrect whitelist outfile contents:", string(savedBytes))
 	}
+	savedBytes, err = ioutil.ReadFile(path.Join(outfilePath, "currencies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(savedBytes) != testExpectedCurrenciesData {
+		t.Fatal("Incorrect currencies outfile contents:", string(savedBytes))
+	}
+	status = readTestStatus(t, outfilePath)
+	if !status.OK || status.LastSuccessAt == nil || !status.LastSuccessAt.Equal(status.GeneratedAt) || len(status.Providers) != 2 {
+		t.Fatal("Incorrect successful status:", status)
+	}
+
+	// A failed run keeps the time of the last successful one
+	lastSuccessAt := *status.LastSuccessAt
+	SetSymbolPolicy(DefaultSymbolPolicy())
+	err = Fetch(stream, conf, NewFileSystemWriter(outfilePath))
+	if err == nil {
+		t.Fatal("Expected fetch to fail")
+	}
+	status = readTestStatus(t, outfilePath)
+	if status.OK || status.LastSuccessAt == nil || !status.LastSuccessAt.Equal(lastSuccessAt) {
+		t.Fatal("Incorrect status after failed run:", status)
+	}
+}
+
+func TestFetchWithResult(t *testing.T) {
+	disableMocksFn := createHTTPMocks()
+	defer disableMocksFn()
+
+	err := SetSymbolPolicy(SymbolPolicy{Pinned: DefaultSymbolPolicy().Pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGPubkey = "pubkey"
+	conf.BTCAVGPrivkey = "privkey"
+	conf.Providers = []string{"btcavg"}
+	conf.Symbols = []string{"BTC", "USD", "MISSING"}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var written []byte
+	result, err := FetchWithResult(health.NewStream(), conf,
+		NamedWriter{"broken", func(*health.Job, []Artifact) error { return errors.New("broken") }},
+		NamedWriter{"test", func(_ *health.Job, artifacts []Artifact) error {
+			if artifacts[0].Name == "api" {
+				written = testArtifactData(t, artifacts, "api")
+			}
+			return nil
+		}},
+	)
+	if err == nil || err.Error() != "broken" {
+		t.Fatal("Expected writer error, got:", err)
+	}
+	if result.OK || len(result.Providers) != 1 || result.Providers[0].Name != "btcavg" {
+		t.Fatal("Incorrect result:", result)
+	}
+	if result.Symbols[exchangeRateTypeFiat.String()] != 1 || result.Symbols[exchangeRateTypeCrypto.String()] != 1 {
+		t.Fatal("Incorrect symbol counts:", result.Symbols)
+	}
+	expectedWriters := []WriterResult{{Name: "broken", Error: "broken"}, {Name: "test", OK: true}}
+	if !reflect.DeepEqual(result.Writers, expectedWriters) {
+		t.Fatal("Incorrect writer results:", result.Writers)
+	}
+
+	rates := exchangeRates{}
+	err = json.Unmarshal(written, &rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates["USD"].Last == "" {
+		t.Fatal("Incorrect filtered rates:", rates)
+	}
+
+	conf.Providers = []string{"unknown"}
+	if _, ok := conf.Validate().(errInvalidConfig); !ok {
+		t.Fatal("Expected unknown provider to be invalid")
+	}
+}
+
+func TestFetchWritesStatusLast(t *testing.T) {
+	disableMocksFn := createHTTPMocks()
+	defer disableMocksFn()
+
+	err := SetSymbolPolicy(SymbolPolicy{Pinned: DefaultSymbolPolicy().Pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGPubkey = "pubkey"
+	conf.BTCAVGPrivkey = "privkey"
+	conf.Providers = []string{"btcavg"}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failing writer loses the rates but still takes the status, which
+	// must not claim the run succeeded for it
+	written := map[string][]string{}
+	statuses := map[string]*Status{}
+	recordWriter := func(name string, failData bool) NamedWriter {
+		return NamedWriter{name, func(_ *health.Job, artifacts []Artifact) error {
+			for _, artifact := range artifacts {
+				if artifact.Name == "status" {
+					statuses[name] = &Status{}
+					if err := json.Unmarshal(artifact.Data, statuses[name]); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if failData {
+					return errors.New("disk full")
+				}
+				written[name] = append(written[name], artifact.Name)
+			}
+			return nil
+		}}
+	}
+	result, err := FetchWithResult(health.NewStream(), conf, recordWriter("failing", true), recordWriter("ok", false))
+	if err == nil || err.Error() != "disk full" {
+		t.Fatal("Expected writer error, got:", err)
+	}
+	expectedWriters := []WriterResult{{Name: "failing", Error: "disk full"}, {Name: "ok", OK: true}}
+	if !reflect.DeepEqual(result.Writers, expectedWriters) {
+		t.Fatal("Incorrect writer results:", result.Writers)
+	}
+
+	if len(written["failing"]) != 0 || len(written["ok"]) == 0 {
+		t.Fatal("Incorrect artifacts written:", written)
+	}
+	failed := statuses["failing"]
+	if failed == nil || failed.OK || failed.Error != "disk full" || failed.LastSuccessAt != nil {
+		t.Fatal("Incorrect status for the failing writer:", failed)
+	}
+	succeeded := statuses["ok"]
+	if succeeded == nil || !succeeded.OK || succeeded.LastSuccessAt == nil {
+		t.Fatal("Incorrect status for the working writer:", succeeded)
+	}
+}
+
+func readTestStatus(t *testing.T, outfilePath string) *Status {
+	data, err := ioutil.ReadFile(path.Join(outfilePath, "status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := &Status{}
+	err = json.Unmarshal(data, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func testArtifactData(t *testing.T, artifacts []Artifact, name string) []byte {
+	for _, artifact := range artifacts {
+		if artifact.Name == name {
+			return artifact.Data
+		}
+	}
+	t.Fatal("Missing artifact:", name)
+	return nil
 }
 
 func createHTTPMocks() func() {
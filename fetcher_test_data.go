@@ -1,16 +1,23 @@
 package ticker
 
-import "regexp"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
 
 const testCMCQueryLimit = 5
 
+var testCMCBaseURL = fmt.Sprintf(cmcBaseURLTemplate, "sandbox")
+
 var httpMocks = map[string]string{
-	btcavgFiatEndpoint: `{
+	btcavgDefaultBaseURL + btcavgFiatPath: `{
 		"BTCUSD": {"ask": "1","bid": "2","last": "3"},
 		"NOTABTCRATE": {}
 	}`,
 
-	btcavgCryptoEndpoint: `{
+	btcavgDefaultBaseURL + btcavgCryptoPath: `{
 		"BCHBTC": {"ask": "0.5","0.5": "0.5","last": "0.5"},
 		"NOTBTC": {"ask": "121","bid": "122","last": "123"},
 		"SOILBTC": {"ask": "0.0012345","bid": "0.0012345","last": "0.0012345"},
@@ -20,7 +27,7 @@ var httpMocks = map[string]string{
 		"NOTANALTCOINRATE": {}
 	}`,
 
-	buildCMCEndpoint("sandbox"): `{
+	buildCMCEndpoint(testCMCBaseURL): `{
 		"metadata": {"num_cryptocurrencies": 102},
 		"data": [
 			{
@@ -51,17 +58,19 @@ var httpMocks = map[string]string{
 		]
 	}`,
 
-	buildCMCEndpoint("sandbox"): `{
+	buildCMCEndpoint(testCMCBaseURL): `{
 		"metadata": {"num_cryptocurrencies": 102},
 		"data": [
 			{
 				"id": 101,
 				"symbol": "$$$",
+				"name": "Money",
 				"quote": {"BTC": {"price": 0.101}}
 			},
 			{
 				"id": 102,
 				"symbol": "IOTA",
+				"name": "IOTA",
 				"quote": {"BTC": {"price": 0.00102}}
 			}
 		]
@@ -88,8 +97,8 @@ var testExpectedFetchData = regexp.MustCompile("\\s").ReplaceAllString(`{
 			"type": "crypto"
 	},
 	"NOT": {
-			"ask": 0.008264462,
-			"bid": 0.008196721,
+			"ask": 0.008196721,
+			"bid": 0.008264462,
 			"last": 0.008130081,
 			"type": "crypto"
 	},
@@ -106,3 +115,21 @@ var testExpectedFetchData = regexp.MustCompile("\\s").ReplaceAllString(`{
 			"type": "fiat"
 	}
 }`, "")
+
+var testExpectedCurrenciesData = compactTestJSON(`{
+	"$$$": {"name": "Money", "id": 101, "type": "crypto", "decimals": 8},
+	"BTC": {"name": "Bitcoin", "id": 1, "type": "crypto", "decimals": 8},
+	"MIOTA": {"name": "IOTA", "id": 102, "type": "crypto", "decimals": 8},
+	"NOT": {"type": "crypto", "decimals": 8},
+	"SOIL": {"type": "crypto", "decimals": 8},
+	"USD": {"name": "US Dollar", "type": "fiat", "decimals": 2}
+}`)
+
+func compactTestJSON(data string) string {
+	buf := &bytes.Buffer{}
+	err := json.Compact(buf, []byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,131 @@
+package ticker
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gocraft/health"
+)
+
+var (
+	recordFixtures = flag.Bool("record", false, "record provider responses into testdata/fixtures with the API keys from the environment, and update the golden files")
+	updateGolden   = flag.Bool("update", false, "update the golden files in testdata/golden")
+)
+
+const (
+	testFixturesDir = "testdata/fixtures"
+	testGoldenDir   = "testdata/golden"
+)
+
+// goldenArtifacts are the artifacts compared against golden files. The status
+// changes on every run and is left out.
+var goldenArtifacts = []string{"api", "whitelist", "currencies", "api_v2"}
+
+func TestGoldenFetch(t *testing.T) {
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGPubkey = "pubkey"
+	conf.BTCAVGPrivkey = "privkey"
+	conf.CMCAPIKey = "cmc-api-key"
+
+	// Fixtures hold the CMC pages production requests, not the small test pages
+	cmcQueryLimit = cmcDefaultQueryLimit
+	defer func() { cmcQueryLimit = testCMCQueryLimit }()
+
+	transport := http.RoundTripper(&ReplayTransport{Dir: testFixturesDir})
+	if *recordFixtures {
+		conf = NewConfig()
+		conf.OutPath = ""
+		os.RemoveAll(testFixturesDir)
+		transport = &RecordingTransport{Dir: testFixturesDir, Base: http.DefaultTransport}
+	}
+	httpClient.Transport = transport
+	defer func() { httpClient.Transport = nil }()
+
+	// Required symbols are covered by TestFetch
+	err := SetSymbolPolicy(SymbolPolicy{
+		Pinned:  DefaultSymbolPolicy().Pinned,
+		Aliases: DefaultSymbolPolicy().Aliases,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	writer := NewMemoryWriter()
+	err = Fetch(health.NewStream(), conf, writer.Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range goldenArtifacts {
+		data := writer.artifacts[name].Data
+		goldenPath := filepath.Join(testGoldenDir, name+".json")
+		if *updateGolden || *recordFixtures {
+			err := ioutil.WriteFile(goldenPath, data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%s differs from %s; run go test -run TestGoldenFetch -update if the change is intended\nGot:\n%s", name, goldenPath, data)
+		}
+	}
+}
+
+func TestReplayTransportScrubsCredentials(t *testing.T) {
+	disableMocksFn := createHTTPMocks()
+	defer disableMocksFn()
+
+	dir, err := ioutil.TempDir("", "ticker_proxy_fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	httpClient.Transport = &RecordingTransport{Dir: dir, Base: http.DefaultTransport}
+	defer func() { httpClient.Transport = nil }()
+	_, err = NewCMCFetcher(testCMCBaseURL, "secret-api-key")(health.NewStream().NewJob("test"), httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No fixtures were recorded")
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret-api-key")) || !bytes.Contains(data, []byte(scrubbedValue)) {
+			t.Fatal("Fixture was not scrubbed:", string(data))
+		}
+	}
+
+	// The recorded responses are replayed without the mocks
+	disableMocksFn()
+	httpClient.Transport = &ReplayTransport{Dir: dir}
+	rates, err := NewCMCFetcher(testCMCBaseURL, "other-api-key")(health.NewStream().NewJob("test"), httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) == 0 {
+		t.Fatal("No rates were replayed")
+	}
+}
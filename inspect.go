@@ -0,0 +1,136 @@
+package ticker
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/gocraft/health"
+)
+
+// InspectedRate is the rate for a symbol from a single source
+type InspectedRate struct {
+	Source string      `json:"source"`
+	Ask    json.Number `json:"ask"`
+	Bid    json.Number `json:"bid"`
+	Last   json.Number `json:"last"`
+	Type   string      `json:"type"`
+	Name   string      `json:"name,omitempty"`
+	ID     int64       `json:"id,omitempty"`
+}
+
+func newInspectedRate(source string, rate exchangeRate) *InspectedRate {
+	return &InspectedRate{
+		Source: source,
+		Ask:    rate.Ask,
+		Bid:    rate.Bid,
+		Last:   rate.Last,
+		Type:   rate.Type,
+		Name:   rate.Name,
+		ID:     rate.CMCID,
+	}
+}
+
+// SymbolInspection describes how a symbol is treated by the current symbol
+// policy and what each source reports for it
+type SymbolInspection struct {
+	Symbol    string   `json:"symbol"`
+	Canonical string   `json:"canonical"`
+	Aliases   []string `json:"aliases"`
+	Required  bool     `json:"required"`
+	Banned    bool     `json:"banned"`
+	PinnedID  int64    `json:"pinnedId,omitempty"`
+
+	// Sources are the rates reported by each provider that has the symbol
+	Sources []*InspectedRate `json:"sources"`
+
+	// Merged is the rate a run would publish, if any
+	Merged *InspectedRate `json:"merged"`
+
+	// Overridden is the action and reason of the symbol's override, if any
+	Overridden string `json:"overridden,omitempty"`
+
+	// Filtered is the reason the market floors removed the symbol, if they did
+	Filtered string `json:"filtered,omitempty"`
+
+	// Dropped is the reason validation removed the symbol, if it did
+	Dropped string `json:"dropped,omitempty"`
+
+	// Published is the rate in the currently published api document, if any
+	Published *InspectedRate `json:"published"`
+}
+
+// Inspect fetches data from all sources and reports how the given symbol is
+// resolved, fetched, merged and published
+func Inspect(stream *health.Stream, conf Config, symbol string) (*SymbolInspection, error) {
+	job := stream.NewJob("inspect")
+
+	canonical := CanonicalizeSymbol(symbol)
+	policy := CurrentSymbolPolicy()
+	inspection := &SymbolInspection{
+		Symbol:    symbol,
+		Canonical: canonical,
+		Aliases:   []string{},
+		Banned:    isBannedCryptoSymbol(canonical),
+		PinnedID:  policy.Pinned[canonical],
+		Sources:   []*InspectedRate{},
+	}
+	for alias, aliased := range policy.Aliases {
+		if aliased == canonical {
+			inspection.Aliases = append(inspection.Aliases, alias)
+		}
+	}
+	sort.Strings(inspection.Aliases)
+	for _, required := range policy.Required() {
+		if required == canonical {
+			inspection.Required = true
+		}
+	}
+
+	publishedRates, err := loadPublishedRates(conf)
+	if err != nil {
+		job.EventErr("load_published_rates", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+	if rate, ok := publishedRates[canonical]; ok {
+		inspection.Published = newInspectedRate("published", rate)
+	}
+
+	fetched, _, err := fetchProviders(job, conf)
+	if err != nil {
+		job.Complete(health.Error)
+		return nil, err
+	}
+	for _, f := range fetched {
+		if rate, ok := f.rates[canonical]; ok {
+			inspection.Sources = append(inspection.Sources, newInspectedRate(f.provider, rate))
+		}
+	}
+
+	history, err := loadSmoothingHistory(conf)
+	if err != nil {
+		job.EventErr("load_rate_history", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	overrides, err := LoadOverrides(conf)
+	if err != nil {
+		job.EventErr("load_overrides", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	// Validation failures don't matter here; we only want the outcome for
+	// this symbol
+	rates, report, _ := mergeAndValidateRates(job, conf, fetched, publishedRates, history, overrides)
+	if rate, ok := rates[canonical]; ok {
+		inspection.Merged = newInspectedRate("merged", rate)
+	}
+	inspection.Overridden = report.Overridden[canonical]
+	inspection.Filtered = report.Filtered[canonical]
+	inspection.Dropped = report.Dropped[canonical]
+
+	job.Complete(health.Success)
+	return inspection, nil
+}
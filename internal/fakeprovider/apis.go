@@ -0,0 +1,139 @@
+package fakeprovider
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Paths of the emulated endpoints, which are the same as the real APIs' so the
+// ticker can be pointed at the fake provider with its base URLs
+const (
+	btcavgFiatPath       = "/indices/global/ticker/all"
+	btcavgCryptoPath     = "/indices/crypto/ticker/all"
+	cmcListingsPath      = "/v1/cryptocurrency/listings/latest"
+	cmcQuotesPath        = "/v1/cryptocurrency/quotes/latest"
+	cmcIDMapPath         = "/v1/cryptocurrency/map"
+	krakenTickerPath     = "/0/public/Ticker"
+	coinbaseProductsPath = "/products/"
+	ecbDailyPath         = "/stats/eurofxref/eurofxref-daily.xml"
+)
+
+// krakenBookDepth is the depth of the Kraken books, which is the one the ticker
+// subscribes to
+const krakenBookDepth = 10
+
+// iso4217Codes are the active ISO 4217 currency codes, in order
+var iso4217Codes = []string{
+	"AED", "AFN", "ALL", "AMD", "ANG", "AOA", "ARS", "AUD", "AWG", "AZN", "BAM",
+	"BBD", "BDT", "BGN", "BHD", "BIF", "BMD", "BND", "BOB", "BRL", "BSD", "BTN",
+	"BWP", "BYN", "BZD", "CAD", "CDF", "CHF", "CLF", "CLP", "CNY", "COP", "CRC",
+	"CUP", "CVE", "CZK", "DJF", "DKK", "DOP", "DZD", "EGP", "ERN", "ETB", "EUR",
+	"FJD", "FKP", "GBP", "GEL", "GHS", "GIP", "GMD", "GNF", "GTQ", "GYD", "HKD",
+	"HNL", "HTG", "HUF", "IDR", "ILS", "INR", "IQD", "IRR", "ISK", "JMD", "JOD",
+	"JPY", "KES", "KGS", "KHR", "KMF", "KPW", "KRW", "KWD", "KYD", "KZT", "LAK",
+	"LBP", "LKR", "LRD", "LSL", "LYD", "MAD", "MDL", "MGA", "MKD", "MMK", "MNT",
+	"MOP", "MRU", "MUR", "MVR", "MWK", "MXN", "MYR", "MZN", "NAD", "NGN", "NIO",
+	"NOK", "NPR", "NZD", "OMR", "PAB", "PEN", "PGK", "PHP", "PKR", "PLN", "PYG",
+	"QAR", "RON", "RSD", "RUB", "RWF", "SAR", "SBD", "SCR", "SDG", "SEK", "SGD",
+	"SHP", "SLE", "SOS", "SRD", "SSP", "STN", "SVC", "SYP", "SZL", "THB", "TJS",
+	"TMT", "TND", "TOP", "TRY", "TTD", "TWD", "TZS", "UAH", "UGX", "USD", "UYU",
+	"UZS", "VES", "VND", "VUV", "WST", "XAF", "XCD", "XOF", "XPF", "YER", "ZAR",
+	"ZMW", "ZWL",
+}
+
+// krakenQuoteAssets are the Kraken asset codes pairs are split on, longest
+// first
+var krakenQuoteAssets = []string{
+	"ZUSD", "ZEUR", "ZGBP", "ZCAD", "ZJPY", "XXBT", "XETH",
+	"USDT", "USDC", "USD", "EUR", "GBP", "CAD", "JPY", "CHF", "AUD", "XBT", "ETH",
+}
+
+// krakenAssets maps Kraken's asset codes to ours where they differ
+var krakenAssets = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// parseKrakenPair splits a Kraken pair name, e.g. XXBTZUSD or ETHXBT, into
+// base and quote symbols
+func parseKrakenPair(pair string) (string, string, bool) {
+	for _, quote := range krakenQuoteAssets {
+		if !strings.HasSuffix(pair, quote) || len(pair) == len(quote) {
+			continue
+		}
+		// Prefixed quotes only follow prefixed bases, e.g. XXBTZUSD, so
+		// XTZUSD is XTZ in USD rather than XT in ZUSD
+		base := strings.TrimSuffix(pair, quote)
+		if isKrakenPrefixedAsset(quote) && (len(base) != 4 || base[0] != 'X') {
+			continue
+		}
+		return krakenSymbol(base), krakenSymbol(quote), true
+	}
+	return "", "", false
+}
+
+// isKrakenPrefixedAsset checks if an asset code is one of Kraken's legacy X or
+// Z prefixed codes, e.g. XXBT or ZUSD
+func isKrakenPrefixedAsset(asset string) bool {
+	return len(asset) == 4 && (asset[0] == 'X' || asset[0] == 'Z')
+}
+
+// krakenSymbol returns the symbol for a Kraken asset code. Kraken prefixes its
+// older crypto assets with X and fiat assets with Z.
+func krakenSymbol(asset string) string {
+	if isKrakenPrefixedAsset(asset) {
+		asset = asset[1:]
+	}
+	if symbol, ok := krakenAssets[asset]; ok {
+		asset = symbol
+	}
+	return asset
+}
+
+// krakenChecksum returns the CRC32 checksum Kraken sends with book updates, of
+// the asks from the lowest and the bids from the highest with the dots and
+// leading zeros of their prices and volumes removed
+func krakenChecksum(asks, bids map[string]string) uint32 {
+	var buf strings.Builder
+	for _, side := range []struct {
+		levels     map[string]string
+		descending bool
+	}{{asks, false}, {bids, true}} {
+		for _, price := range sortedPrices(side.levels, side.descending) {
+			buf.WriteString(krakenChecksumDigits(price))
+			buf.WriteString(krakenChecksumDigits(side.levels[price]))
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(buf.String()))
+}
+
+func krakenChecksumDigits(value string) string {
+	return strings.TrimLeft(strings.Replace(value, ".", "", 1), "0")
+}
+
+func sortedPrices(levels map[string]string, descending bool) []string {
+	prices := make([]string, 0, len(levels))
+	values := make(map[string]float64, len(levels))
+	for price := range levels {
+		prices = append(prices, price)
+		values[price], _ = strconv.ParseFloat(price, 64)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return values[prices[i]] > values[prices[j]]
+		}
+		return values[prices[i]] < values[prices[j]]
+	})
+	return prices
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
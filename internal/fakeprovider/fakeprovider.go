@@ -0,0 +1,620 @@
+// Package fakeprovider emulates the provider APIs and exchange feeds the ticker
+// reads, for integration tests and development
+package fakeprovider
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Fake provider scenarios
+const (
+	// ScenarioRandomWalk moves every price a little on each request
+	ScenarioRandomWalk = "random_walk"
+
+	// ScenarioRateLimit answers every provider request with a 429
+	ScenarioRateLimit = "rate_limit"
+
+	// ScenarioNullPrices returns null prices for some of the coins
+	ScenarioNullPrices = "null_prices"
+
+	// ScenarioMalformedJSON returns truncated response bodies
+	ScenarioMalformedJSON = "malformed_json"
+
+	// ScenarioSlow delays every provider response by the configured delay
+	ScenarioSlow = "slow"
+)
+
+var allScenarios = []string{
+	ScenarioRandomWalk,
+	ScenarioRateLimit,
+	ScenarioNullPrices,
+	ScenarioMalformedJSON,
+	ScenarioSlow,
+}
+
+// btcavgSignatureMaxAge is how old a BitcoinAverage signature may be
+const btcavgSignatureMaxAge = 15 * time.Minute
+
+// fakeECBCurrencies are the currencies in the ECB reference rates
+var fakeECBCurrencies = []string{
+	"USD", "JPY", "BGN", "CZK", "DKK", "GBP", "HUF", "PLN", "RON", "SEK", "CHF",
+	"ISK", "NOK", "TRY", "AUD", "BRL", "CAD", "CNY", "HKD", "IDR", "ILS", "INR",
+	"KRW", "MXN", "MYR", "NZD", "PHP", "SGD", "THB", "ZAR",
+}
+
+// knownCoins are real coins served by the fake provider with their
+// CMC IDs, so pinned symbols resolve like they do against the real APIs
+var knownCoins = []fakeCoin{
+	{1, "BTC", "Bitcoin", 1},
+	{1027, "ETH", "Ethereum", 0.05},
+	{1831, "BCH", "Bitcoin Cash", 0.01},
+	{2, "LTC", "Litecoin", 0.003},
+	{1437, "ZEC", "Zcash", 0.002},
+	{328, "XMR", "Monero", 0.004},
+	{131, "DASH", "Dash", 0.002},
+	{74, "DOGE", "Dogecoin", 0.000003},
+}
+
+type fakeCoin struct {
+	id     int64
+	symbol string
+	name   string
+	price  float64 // in BTC
+}
+
+// Provider is an http.Handler that emulates the BitcoinAverage ticker
+// endpoints, the CMC listings, quotes and ID map endpoints, the Kraken and
+// Coinbase tickers and WebSocket feeds and the ECB reference rates for
+// integration tests and development.
+// Requests must carry valid credentials when they are set.
+// Scenarios can be enabled to simulate misbehaving providers, and changed at
+// runtime with PUT /_fake/scenarios?set=a,b.
+type Provider struct {
+	BTCAVGPubkey  string
+	BTCAVGPrivkey string
+	CMCAPIKey     string
+
+	// Delay is how long responses take in the slow scenario
+	Delay time.Duration
+
+	// StreamInterval is how often the WebSocket feeds send updates
+	StreamInterval time.Duration
+
+	mu          sync.Mutex
+	rand        *rand.Rand
+	scenarios   map[string]bool
+	streams     map[*websocket.Conn]struct{}
+	skipUpdates map[string]int     // feed updates to drop by exchange
+	fiat        map[string]float64 // price of 1 BTC
+	coins       []fakeCoin
+}
+
+// New creates a Provider serving every ISO 4217 currency, a few well known
+// coins and the given number of generated coins. Prices are derived from the
+// seed.
+func New(seed int64, generatedCoins int) *Provider {
+	p := &Provider{
+		Delay:          5 * time.Second,
+		StreamInterval: time.Second,
+		rand:           rand.New(rand.NewSource(seed)),
+		scenarios:      map[string]bool{},
+		streams:        map[*websocket.Conn]struct{}{},
+		skipUpdates:    map[string]int{},
+		fiat:           map[string]float64{},
+		coins:          append([]fakeCoin{}, knownCoins...),
+	}
+
+	for _, code := range iso4217Codes {
+		p.fiat[code] = 30000 * math.Pow(10, p.rand.Float64()*4-2)
+	}
+	p.fiat["USD"] = 30000
+
+	for i := 1; i <= generatedCoins; i++ {
+		p.coins = append(p.coins, fakeCoin{
+			id:     int64(100000 + i),
+			symbol: fmt.Sprintf("FAKE%d", i),
+			name:   fmt.Sprintf("Fake Coin %d", i),
+			price:  math.Pow(10, -2-p.rand.Float64()*6),
+		})
+	}
+
+	return p
+}
+
+// SetScenarios replaces the enabled scenarios
+func (p *Provider) SetScenarios(scenarios ...string) error {
+	enabled := map[string]bool{}
+	for _, scenario := range scenarios {
+		if scenario == "" {
+			continue
+		}
+		if !containsString(allScenarios, scenario) {
+			return errUnknownScenario(scenario)
+		}
+		enabled[scenario] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scenarios = enabled
+	return nil
+}
+
+// Scenarios returns the enabled scenarios
+func (p *Provider) Scenarios() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	scenarios := []string{}
+	for _, scenario := range allScenarios {
+		if p.scenarios[scenario] {
+			scenarios = append(scenarios, scenario)
+		}
+	}
+	return scenarios
+}
+
+func (p *Provider) hasScenario(scenario string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scenarios[scenario]
+}
+
+// ServeHTTP routes the request to the emulated endpoint
+func (p *Provider) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/_fake/scenarios" {
+		p.serveScenarios(rw, r)
+		return
+	}
+
+	var (
+		source  string
+		handler func(*http.Request) (interface{}, int)
+	)
+	switch r.URL.Path {
+	case btcavgFiatPath:
+		source, handler = "btcavg", p.btcavgFiat
+	case btcavgCryptoPath:
+		source, handler = "btcavg", p.btcavgCrypto
+	case cmcListingsPath:
+		source, handler = "cmc", p.cmcListings
+	case cmcQuotesPath:
+		source, handler = "cmc", p.cmcQuotes
+	case cmcIDMapPath:
+		source, handler = "cmc", p.cmcIDMap
+	case krakenTickerPath:
+		source, handler = "kraken", p.krakenTicker
+	case ecbDailyPath:
+		p.serveECB(rw, r)
+		return
+	case KrakenStreamPath:
+		p.serveStream(rw, r, "kraken")
+		return
+	case CoinbaseStreamPath:
+		p.serveStream(rw, r, "coinbase")
+		return
+	default:
+		if strings.HasPrefix(r.URL.Path, coinbaseProductsPath) && strings.HasSuffix(r.URL.Path, "/ticker") {
+			source, handler = "coinbase", p.coinbaseTicker
+			break
+		}
+		http.NotFound(rw, r)
+		return
+	}
+
+	if p.hasScenario(ScenarioSlow) {
+		select {
+		case <-time.After(p.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if p.hasScenario(ScenarioRateLimit) {
+		rw.Header().Set("Retry-After", "60")
+		writeFakeResponse(rw, http.StatusTooManyRequests, fakeErrorBody(source, 1008, "You've exceeded your API Key's HTTP request rate limit."), false)
+		return
+	}
+
+	if err := p.authorize(source, r); err != nil {
+		writeFakeResponse(rw, http.StatusUnauthorized, fakeErrorBody(source, 1002, err.Error()), false)
+		return
+	}
+
+	if p.hasScenario(ScenarioRandomWalk) {
+		p.walk()
+	}
+
+	body, status := handler(r)
+	writeFakeResponse(rw, status, body, p.hasScenario(ScenarioMalformedJSON))
+}
+
+// serveECB writes the eurofxref XML document. The ECB API has no credentials
+// and isn't affected by scenarios.
+func (p *Provider) serveECB(rw http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">`)
+	fmt.Fprintf(buf, `<Cube><Cube time="%s">`, time.Now().UTC().Format("2006-01-02"))
+	for _, code := range fakeECBCurrencies {
+		if price, ok := p.fiat[code]; ok {
+			fmt.Fprintf(buf, `<Cube currency="%s" rate="%s"/>`, code, strconv.FormatFloat(price/p.fiat["EUR"], 'f', 4, 64))
+		}
+	}
+	buf.WriteString(`</Cube></Cube></gesmes:Envelope>`)
+
+	rw.Header().Set("Content-Type", "text/xml")
+	rw.Write(buf.Bytes())
+}
+
+func (p *Provider) serveScenarios(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		err := p.SetScenarios(strings.Split(r.URL.Query().Get("set"), ",")...)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeFakeResponse(rw, http.StatusOK, p.Scenarios(), false)
+}
+
+// authorize checks the credentials a provider would require
+func (p *Provider) authorize(source string, r *http.Request) error {
+	switch source {
+	case "kraken", "coinbase":
+		// The public ticker endpoints don't need credentials
+		return nil
+	case "cmc":
+		if p.CMCAPIKey != "" && r.Header.Get("X-CMC_PRO_API_KEY") != p.CMCAPIKey {
+			return errUnauthorized("API key missing or invalid")
+		}
+		return nil
+	}
+
+	if p.BTCAVGPrivkey == "" {
+		return nil
+	}
+	parts := strings.Split(r.Header.Get("X-Signature"), ".")
+	if len(parts) != 3 {
+		return errUnauthorized("missing or malformed X-signature")
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > btcavgSignatureMaxAge {
+		return errUnauthorized("X-signature timestamp is invalid or expired")
+	}
+	if parts[1] != p.BTCAVGPubkey {
+		return errUnauthorized("unknown public key")
+	}
+	mac := hmac.New(sha256.New, []byte(p.BTCAVGPrivkey))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(parts[2])) {
+		return errUnauthorized("X-signature does not match")
+	}
+	return nil
+}
+
+// walk moves every price by a small random amount
+func (p *Provider) walk() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for code, price := range p.fiat {
+		p.fiat[code] = price * math.Exp(p.rand.NormFloat64()*0.01)
+	}
+	for i := range p.coins {
+		if p.coins[i].symbol != "BTC" {
+			p.coins[i].price *= math.Exp(p.rand.NormFloat64() * 0.01)
+		}
+	}
+}
+
+// isNullPrice checks if the coin at the index has a null price in the
+// null_prices scenario
+func (p *Provider) isNullPrice(i int) bool {
+	return p.scenarios[ScenarioNullPrices] && i%10 == 5
+}
+
+type fakeBTCAVGTicker struct {
+	ID   int64   `json:"id,omitempty"`
+	Ask  *string `json:"ask"`
+	Bid  *string `json:"bid"`
+	Last *string `json:"last"`
+}
+
+func newFakeBTCAVGTicker(price float64, null bool) fakeBTCAVGTicker {
+	if null {
+		return fakeBTCAVGTicker{}
+	}
+	ask := strconv.FormatFloat(price*1.001, 'f', -1, 64)
+	bid := strconv.FormatFloat(price*0.999, 'f', -1, 64)
+	last := strconv.FormatFloat(price, 'f', -1, 64)
+	return fakeBTCAVGTicker{Ask: &ask, Bid: &bid, Last: &last}
+}
+
+func (p *Provider) btcavgFiat(r *http.Request) (interface{}, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tickers := map[string]fakeBTCAVGTicker{}
+	for code, price := range p.fiat {
+		tickers["BTC"+code] = newFakeBTCAVGTicker(price, false)
+	}
+	return tickers, http.StatusOK
+}
+
+func (p *Provider) btcavgCrypto(r *http.Request) (interface{}, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tickers := map[string]fakeBTCAVGTicker{}
+	for i, coin := range p.coins {
+		if coin.symbol != "BTC" {
+			ticker := newFakeBTCAVGTicker(coin.price, p.isNullPrice(i))
+			ticker.ID = coin.id
+			tickers[coin.symbol+"BTC"] = ticker
+		}
+	}
+	return tickers, http.StatusOK
+}
+
+func (p *Provider) cmcListings(r *http.Request) (interface{}, int) {
+	start, limit, err := fakeCMCPage(r)
+	if err != nil {
+		return fakeErrorBody("cmc", 400, err.Error()), http.StatusBadRequest
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data := []interface{}{}
+	for i := start - 1; i < len(p.coins) && i < start-1+limit; i++ {
+		data = append(data, p.fakeCMCEntry(i))
+	}
+	return fakeCMCBody(data), http.StatusOK
+}
+
+// cmcQuotes serves the quotes of the coins with the requested IDs, keyed by ID.
+// Like CMC, unknown IDs fail the whole request.
+func (p *Provider) cmcQuotes(r *http.Request) (interface{}, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data := map[string]interface{}{}
+	for _, value := range strings.Split(r.URL.Query().Get("id"), ",") {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fakeErrorBody("cmc", 400, fmt.Sprintf("Invalid value for \"id\": %q", value)), http.StatusBadRequest
+		}
+		found := false
+		for i, coin := range p.coins {
+			if coin.id == id {
+				data[value] = p.fakeCMCEntry(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fakeErrorBody("cmc", 400, fmt.Sprintf("Invalid value for \"id\": %q", value)), http.StatusBadRequest
+		}
+	}
+	return fakeCMCBody(data), http.StatusOK
+}
+
+// fakeCMCEntry returns the CMC listing of the coin at the given index, which is
+// also its rank minus one
+func (p *Provider) fakeCMCEntry(i int) map[string]interface{} {
+	coin := p.coins[i]
+	quote := map[string]interface{}{"price": nil}
+	if !p.isNullPrice(i) {
+		// Later coins trade less, so volume floors drop some of them
+		volume := coin.price * math.Pow(10, 7-float64(i%8))
+		quote = map[string]interface{}{
+			"price":              coin.price,
+			"volume_24h":         volume,
+			"market_cap":         volume * 20,
+			"percent_change_1h":  float64(i%3) - 1,
+			"percent_change_24h": float64(i%7) - 3,
+			"percent_change_7d":  float64(i%11) - 5,
+		}
+	}
+	return map[string]interface{}{
+		"id":       coin.id,
+		"symbol":   coin.symbol,
+		"name":     coin.name,
+		"cmc_rank": i + 1,
+		"quote":    map[string]interface{}{"BTC": quote},
+	}
+}
+
+func (p *Provider) cmcIDMap(r *http.Request) (interface{}, int) {
+	start, limit, err := fakeCMCPage(r)
+	if err != nil {
+		return fakeErrorBody("cmc", 400, err.Error()), http.StatusBadRequest
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data := []interface{}{}
+	for i := start - 1; i < len(p.coins) && i < start-1+limit; i++ {
+		coin := p.coins[i]
+		data = append(data, map[string]interface{}{
+			"id":     coin.id,
+			"symbol": coin.symbol,
+			"name":   coin.name,
+			"rank":   i + 1,
+		})
+	}
+	return fakeCMCBody(data), http.StatusOK
+}
+
+// krakenTicker serves the requested pairs under Kraken's names for them, which
+// are prefixed when both assets are legacy ones
+func (p *Provider) krakenTicker(r *http.Request) (interface{}, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := map[string]interface{}{}
+	for _, pair := range strings.Split(r.URL.Query().Get("pair"), ",") {
+		base, quote, ok := parseKrakenPair(pair)
+		if !ok {
+			return fakeErrorBody("kraken", 0, "EQuery:Unknown asset pair"), http.StatusOK
+		}
+		price, ok := p.pairPrice(base, quote)
+		if !ok {
+			return fakeErrorBody("kraken", 0, "EQuery:Unknown asset pair"), http.StatusOK
+		}
+
+		name := pair
+		if baseCode, quoteCode, ok := fakeKrakenLegacyPair(base, quote); ok {
+			name = baseCode + quoteCode
+		}
+		result[name] = map[string]interface{}{
+			"a": []string{strconv.FormatFloat(price*1.0005, 'f', -1, 64), "1", "1.000"},
+			"b": []string{strconv.FormatFloat(price*0.9995, 'f', -1, 64), "1", "1.000"},
+			"c": []string{strconv.FormatFloat(price, 'f', -1, 64), "0.1"},
+		}
+	}
+	return map[string]interface{}{"error": []string{}, "result": result}, http.StatusOK
+}
+
+// fakeKrakenLegacyPair returns the prefixed asset codes Kraken uses for pairs
+// of its oldest assets
+func fakeKrakenLegacyPair(base, quote string) (string, string, bool) {
+	legacy := map[string]string{
+		"BTC": "XXBT", "ETH": "XETH", "LTC": "XLTC", "XMR": "XXMR", "ZEC": "XZEC",
+		"DOGE": "XXDG", "USD": "ZUSD", "EUR": "ZEUR", "GBP": "ZGBP", "CAD": "ZCAD",
+		"JPY": "ZJPY",
+	}
+	baseCode, baseOK := legacy[base]
+	quoteCode, quoteOK := legacy[quote]
+	return baseCode, quoteCode, baseOK && quoteOK
+}
+
+func (p *Provider) coinbaseTicker(r *http.Request) (interface{}, int) {
+	product := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, coinbaseProductsPath), "/ticker")
+	parts := strings.Split(product, "-")
+	if len(parts) != 2 {
+		return fakeErrorBody("coinbase", 0, "NotFound"), http.StatusNotFound
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	price, ok := p.pairPrice(parts[0], parts[1])
+	if !ok {
+		return fakeErrorBody("coinbase", 0, "NotFound"), http.StatusNotFound
+	}
+	return map[string]interface{}{
+		"ask":   strconv.FormatFloat(price*1.0005, 'f', -1, 64),
+		"bid":   strconv.FormatFloat(price*0.9995, 'f', -1, 64),
+		"price": strconv.FormatFloat(price, 'f', -1, 64),
+		"time":  time.Now().UTC().Format(time.RFC3339),
+	}, http.StatusOK
+}
+
+// pairPrice returns the price of the base in units of the quote. The lock must
+// be held.
+func (p *Provider) pairPrice(base, quote string) (float64, bool) {
+	basePrice, baseOK := p.btcPrice(base)
+	quotePrice, quoteOK := p.btcPrice(quote)
+	if !baseOK || !quoteOK {
+		return 0, false
+	}
+	return basePrice / quotePrice, true
+}
+
+// btcPrice returns the price of a symbol in BTC. The lock must be held.
+func (p *Provider) btcPrice(symbol string) (float64, bool) {
+	if price, ok := p.fiat[symbol]; ok {
+		return 1 / price, true
+	}
+	for _, coin := range p.coins {
+		if coin.symbol == symbol {
+			return coin.price, true
+		}
+	}
+	return 0, false
+}
+
+// fakeCMCPage reads the 1-based start and the limit of a CMC request
+func fakeCMCPage(r *http.Request) (int, int, error) {
+	start, limit := 1, 100
+	var err error
+	if value := r.URL.Query().Get("start"); value != "" {
+		start, err = strconv.Atoi(value)
+		if err != nil || start < 1 {
+			return 0, 0, fmt.Errorf("invalid value for start: %q", value)
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 5000 {
+			return 0, 0, fmt.Errorf("invalid value for limit: %q", value)
+		}
+	}
+	return start, limit, nil
+}
+
+func fakeCMCBody(data interface{}) interface{} {
+	return map[string]interface{}{
+		"status": map[string]interface{}{
+			"timestamp":     time.Now().UTC().Format(time.RFC3339),
+			"error_code":    0,
+			"error_message": nil,
+			"credit_count":  1,
+		},
+		"data": data,
+	}
+}
+
+func fakeErrorBody(source string, code int, message string) interface{} {
+	switch source {
+	case "cmc":
+		return map[string]interface{}{
+			"status": map[string]interface{}{"error_code": code, "error_message": message},
+		}
+	case "kraken":
+		return map[string]interface{}{"error": []string{message}}
+	case "coinbase":
+		return map[string]interface{}{"message": message}
+	}
+	return map[string]interface{}{"error": message, "success": false}
+}
+
+// writeFakeResponse writes the body as JSON, truncated when malformed is set
+func writeFakeResponse(rw http.ResponseWriter, status int, body interface{}, malformed bool) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if malformed {
+		data = data[:len(data)/2]
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(data)
+}
+
+type errUnknownScenario string
+
+func (e errUnknownScenario) Error() string {
+	return "Unknown fake provider scenario: " + string(e)
+}
+
+type errUnauthorized string
+
+func (e errUnauthorized) Error() string {
+	return string(e)
+}
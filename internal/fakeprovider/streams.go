@@ -0,0 +1,425 @@
+package fakeprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Paths of the WebSocket ticker feeds
+const (
+	KrakenStreamPath   = "/ws/kraken"
+	CoinbaseStreamPath = "/ws/coinbase"
+)
+
+var fakeStreamUpgrader = websocket.Upgrader{}
+
+// fakeStreamMarket is a market subscribed to on a fake feed connection. Its
+// state is kept when it is unsubscribed from so resubscribing carries on where
+// it left off.
+type fakeStreamMarket struct {
+	name     string
+	base     string
+	quote    string
+	channels map[string]bool
+
+	// updates counts the updates sent, or dropped, for the market
+	updates int64
+
+	// book is the Kraken book clients were last sent, by price and volume
+	book [2]map[string]string
+}
+
+// fakeStreamSession is the state of a single fake feed connection
+type fakeStreamSession struct {
+	provider *Provider
+	exchange string
+	conn     *websocket.Conn
+	markets  map[string]*fakeStreamMarket
+	sequence int64
+}
+
+// DropStreams closes every open WebSocket feed connection, like an exchange
+// dropping its clients
+func (p *Provider) DropStreams() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.streams {
+		conn.Close()
+	}
+}
+
+// SkipStreamUpdates drops the next count updates of the exchange's feeds: the
+// tickers for Coinbase, whose heartbeats still report the trades, and the book
+// updates for Kraken, whose later checksums then don't match. Clients should
+// notice the gap and resubscribe.
+func (p *Provider) SkipStreamUpdates(exchange string, count int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.skipUpdates[exchange] += count
+}
+
+// skipUpdate checks if the next update of the exchange's feeds is dropped
+func (p *Provider) skipUpdate(exchange string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.skipUpdates[exchange] == 0 {
+		return false
+	}
+	p.skipUpdates[exchange]--
+	return true
+}
+
+// serveStream answers ticker and book channel subscriptions with an update for
+// each market every StreamInterval. Coinbase clients also get heartbeats.
+// Scenarios other than random_walk don't affect the feeds.
+func (p *Provider) serveStream(rw http.ResponseWriter, r *http.Request, exchange string) {
+	conn, err := fakeStreamUpgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.streams[conn] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.streams, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Requests are read in the background so markets can be subscribed to
+	// while updates are sent
+	requests := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case requests <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	session := &fakeStreamSession{provider: p, exchange: exchange, conn: conn, markets: map[string]*fakeStreamMarket{}}
+	interval := time.NewTicker(p.StreamInterval)
+	defer interval.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case request := <-requests:
+			if err := session.handleRequest(request); err != nil {
+				session.writeError(err)
+				return
+			}
+		case <-interval.C:
+			if p.hasScenario(ScenarioRandomWalk) {
+				p.walk()
+			}
+			if err := session.sendUpdates(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleRequest subscribes to or unsubscribes from the markets in a request.
+// New subscriptions are answered with a snapshot of the market.
+func (s *fakeStreamSession) handleRequest(message []byte) error {
+	request := struct {
+		Type         string   `json:"type"`
+		ProductIDs   []string `json:"product_ids"`
+		Channels     []string `json:"channels"`
+		Event        string   `json:"event"`
+		Pair         []string `json:"pair"`
+		Subscription struct {
+			Name string `json:"name"`
+		} `json:"subscription"`
+	}{}
+	if err := json.Unmarshal(message, &request); err != nil {
+		return err
+	}
+
+	action, names, channels, separator := request.Type, request.ProductIDs, request.Channels, "-"
+	if s.exchange == "kraken" {
+		action, names, channels, separator = request.Event, request.Pair, []string{request.Subscription.Name}, "/"
+	}
+	if action != "subscribe" && action != "unsubscribe" {
+		return fmt.Errorf("unknown request %q", action)
+	}
+
+	for _, name := range names {
+		market, err := s.market(name, separator)
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			subscribed := market.channels[channel]
+			market.channels[channel] = action == "subscribe"
+			if s.exchange == "kraken" {
+				status := map[string]interface{}{"event": "subscriptionStatus", "status": action + "d", "pair": name, "subscription": map[string]string{"name": channel}}
+				if err := s.write(status); err != nil {
+					return err
+				}
+			}
+			if action == "subscribe" && !subscribed {
+				if err := s.sendSnapshot(market, channel); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if s.exchange == "coinbase" {
+		return s.write(map[string]interface{}{"type": "subscriptions", "channels": s.coinbaseSubscriptions()})
+	}
+	return nil
+}
+
+// market returns the state of the named market, e.g. XBT/USD for Kraken or
+// BTC-USD for Coinbase
+func (s *fakeStreamSession) market(name string, separator string) (*fakeStreamMarket, error) {
+	if market, ok := s.markets[name]; ok {
+		return market, nil
+	}
+
+	parts := strings.Split(name, separator)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%s is not a valid market", name)
+	}
+	market := &fakeStreamMarket{name: name, base: parts[0], quote: parts[1], channels: map[string]bool{}}
+	if s.exchange == "kraken" {
+		market.base, market.quote = krakenSymbol(parts[0]), krakenSymbol(parts[1])
+	}
+
+	s.provider.mu.Lock()
+	_, ok := s.provider.pairPrice(market.base, market.quote)
+	s.provider.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid market", name)
+	}
+	s.markets[name] = market
+	return market, nil
+}
+
+func (s *fakeStreamSession) coinbaseSubscriptions() []interface{} {
+	subscriptions := []interface{}{}
+	for _, channel := range []string{"ticker", "heartbeat"} {
+		products := []string{}
+		for _, market := range s.sortedMarkets() {
+			if market.channels[channel] {
+				products = append(products, market.name)
+			}
+		}
+		subscriptions = append(subscriptions, map[string]interface{}{"name": channel, "product_ids": products})
+	}
+	return subscriptions
+}
+
+func (s *fakeStreamSession) sortedMarkets() []*fakeStreamMarket {
+	markets := make([]*fakeStreamMarket, 0, len(s.markets))
+	for _, market := range s.markets {
+		markets = append(markets, market)
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].name < markets[j].name })
+	return markets
+}
+
+// sendSnapshot sends the current ticker or book of a newly subscribed market
+func (s *fakeStreamSession) sendSnapshot(market *fakeStreamMarket, channel string) error {
+	switch {
+	case channel == "ticker":
+		return s.write(s.ticker(market))
+	case channel == "book" && s.exchange == "kraken":
+		market.book = s.book(market)
+		return s.write([]interface{}{
+			43,
+			map[string]interface{}{"as": fakeBookLevels(nil, market.book[0]), "bs": fakeBookLevels(nil, market.book[1])},
+			fmt.Sprintf("book-%d", krakenBookDepth),
+			market.name,
+		})
+	}
+	return nil
+}
+
+// sendUpdates sends an update of every subscribed market, and the Coinbase
+// heartbeats
+func (s *fakeStreamSession) sendUpdates() error {
+	for _, market := range s.sortedMarkets() {
+		var messages []interface{}
+		if s.exchange == "coinbase" {
+			messages = s.coinbaseUpdate(market)
+		} else {
+			messages = s.krakenUpdate(market)
+		}
+		for _, message := range messages {
+			if err := s.write(message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeStreamSession) coinbaseUpdate(market *fakeStreamMarket) []interface{} {
+	messages := []interface{}{}
+	if market.channels["ticker"] {
+		market.updates++
+		s.sequence++
+		if !s.provider.skipUpdate(s.exchange) {
+			messages = append(messages, s.ticker(market))
+		}
+	}
+	if market.channels["heartbeat"] {
+		s.sequence++
+		messages = append(messages, map[string]interface{}{
+			"type":          "heartbeat",
+			"sequence":      s.sequence,
+			"last_trade_id": market.updates,
+			"product_id":    market.name,
+			"time":          time.Now().UTC().Format(time.RFC3339Nano),
+		})
+	}
+	return messages
+}
+
+func (s *fakeStreamSession) krakenUpdate(market *fakeStreamMarket) []interface{} {
+	messages := []interface{}{}
+	if market.channels["ticker"] {
+		messages = append(messages, s.ticker(market))
+	}
+	if market.channels["book"] {
+		market.updates++
+		book := s.book(market)
+		asks, bids := fakeBookLevels(market.book[0], book[0]), fakeBookLevels(market.book[1], book[1])
+		market.book = book
+		if !s.provider.skipUpdate(s.exchange) {
+			checksum := krakenChecksum(book[0], book[1])
+			messages = append(messages, []interface{}{
+				43,
+				map[string]interface{}{"a": asks},
+				map[string]interface{}{"b": bids, "c": strconv.FormatUint(uint64(checksum), 10)},
+				fmt.Sprintf("book-%d", krakenBookDepth),
+				market.name,
+			})
+		}
+	}
+	return messages
+}
+
+// ticker returns a ticker message for the market's current price
+func (s *fakeStreamSession) ticker(market *fakeStreamMarket) interface{} {
+	s.provider.mu.Lock()
+	price, _ := s.provider.pairPrice(market.base, market.quote)
+	s.provider.mu.Unlock()
+	ask := strconv.FormatFloat(price*1.0005, 'f', -1, 64)
+	bid := strconv.FormatFloat(price*0.9995, 'f', -1, 64)
+	last := strconv.FormatFloat(price, 'f', -1, 64)
+
+	if s.exchange == "kraken" {
+		return []interface{}{
+			42,
+			map[string]interface{}{
+				"a": []string{ask, "1", "1.000"},
+				"b": []string{bid, "1", "1.000"},
+				"c": []string{last, "0.1"},
+			},
+			"ticker",
+			market.name,
+		}
+	}
+	return map[string]interface{}{
+		"type":       "ticker",
+		"sequence":   s.sequence,
+		"trade_id":   market.updates,
+		"product_id": market.name,
+		"price":      last,
+		"best_ask":   ask,
+		"best_bid":   bid,
+		"time":       time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// book returns the Kraken book of the market's current price. Each update
+// changes the volume of the next level on each side, so a dropped update leaves
+// clients with a book that doesn't match the checksum until the level changes
+// again.
+func (s *fakeStreamSession) book(market *fakeStreamMarket) [2]map[string]string {
+	s.provider.mu.Lock()
+	price, _ := s.provider.pairPrice(market.base, market.quote)
+	s.provider.mu.Unlock()
+
+	book := [2]map[string]string{{}, {}}
+	for i := 0; i < krakenBookDepth; i++ {
+		// The volume is set by the last update to change the level
+		volume := 1.0
+		if changedBy := market.updates - (market.updates-int64(i))%krakenBookDepth; market.updates >= int64(i) && changedBy > 0 {
+			volume += float64(changedBy) / 100
+		}
+		offset := 0.0005 * float64(i+1)
+		book[0][fakeKrakenPrice(price*(1+offset))] = strconv.FormatFloat(volume, 'f', 8, 64)
+		book[1][fakeKrakenPrice(price*(1-offset))] = strconv.FormatFloat(volume, 'f', 8, 64)
+	}
+	return book
+}
+
+// fakeKrakenPrice formats a price with 8 significant digits, like Kraken's
+// per pair precision
+func fakeKrakenPrice(price float64) string {
+	decimals := 7 - int(math.Floor(math.Log10(price)))
+	if decimals < 0 {
+		decimals = 0
+	}
+	return strconv.FormatFloat(price, 'f', decimals, 64)
+}
+
+// fakeBookLevels returns the levels that turn the old side of a book into the
+// new one, with removed levels at a volume of 0
+func fakeBookLevels(old map[string]string, new map[string]string) [][]string {
+	timestamp := strconv.FormatFloat(float64(time.Now().UnixNano())/1e9, 'f', 6, 64)
+	levels := [][]string{}
+	for price := range old {
+		if _, ok := new[price]; !ok {
+			levels = append(levels, []string{price, "0.00000000", timestamp})
+		}
+	}
+	for price, volume := range new {
+		if old[price] != volume {
+			levels = append(levels, []string{price, volume, timestamp})
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i][0] < levels[j][0] })
+	return levels
+}
+
+func (s *fakeStreamSession) writeError(err error) {
+	var body interface{} = map[string]string{"type": "error", "message": "Failed to subscribe", "reason": err.Error()}
+	if s.exchange == "kraken" {
+		body = map[string]string{"event": "subscriptionStatus", "status": "error", "errorMessage": err.Error()}
+	}
+	s.write(body)
+}
+
+func (s *fakeStreamSession) write(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
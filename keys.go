@@ -0,0 +1,114 @@
+package ticker
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gocraft/health"
+)
+
+// keyUsage counts what a single API key has been used for since the process
+// started
+type keyUsage struct {
+	requests int64
+	credits  int64
+}
+
+// providerKeys tracks which of a provider's API keys is in use and how much
+// each has been used
+type providerKeys struct {
+	current int
+	usage   []keyUsage
+}
+
+var (
+	apiKeysMu sync.Mutex
+
+	// apiKeys holds the key state of each provider. The key in use is kept
+	// across runs so a rate limited key isn't tried first every run.
+	apiKeys = map[string]*providerKeys{}
+)
+
+// rotateKeys makes a request with each of a provider's count API keys in turn,
+// starting with the key in use, until one isn't rate limited or rejected. The
+// request returns the credits it used, if the provider reports them. The usage
+// of each key tried is emitted as a keys.usage event.
+func rotateKeys(job *health.Job, provider string, count int, request func(key int) (int, error)) error {
+	if count < 1 {
+		count = 1
+	}
+	start := currentKey(provider, count)
+
+	var err error
+	for i := 0; i < count; i++ {
+		key := (start + i) % count
+
+		var credits int
+		credits, err = request(key)
+		usage := recordKeyUsage(provider, count, key, credits)
+		job.EventKv("keys.usage", health.Kvs{
+			"provider": provider,
+			"key":      strconv.Itoa(key),
+			"requests": strconv.FormatInt(usage.requests, 10),
+			"credits":  strconv.FormatInt(usage.credits, 10),
+		})
+
+		if !isKeyExhausted(err) || count == 1 {
+			return err
+		}
+
+		next := (key + 1) % count
+		switchKey(provider, count, key, next)
+		job.EventErrKv("keys.rotate", err, health.Kvs{
+			"provider": provider,
+			"key":      strconv.Itoa(key),
+			"next":     strconv.Itoa(next),
+		})
+	}
+	return err
+}
+
+// providerKeyState returns the key state of a provider, resetting it when the
+// number of keys changed. apiKeysMu must be held.
+func providerKeyState(provider string, count int) *providerKeys {
+	state, ok := apiKeys[provider]
+	if !ok || len(state.usage) != count {
+		state = &providerKeys{usage: make([]keyUsage, count)}
+		apiKeys[provider] = state
+	}
+	return state
+}
+
+func currentKey(provider string, count int) int {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	return providerKeyState(provider, count).current
+}
+
+func recordKeyUsage(provider string, count int, key int, credits int) keyUsage {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	usage := &providerKeyState(provider, count).usage[key]
+	usage.requests++
+	usage.credits += int64(credits)
+	return *usage
+}
+
+// switchKey moves a provider on from a key, unless a concurrent request already
+// did
+func switchKey(provider string, count int, from int, to int) {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	state := providerKeyState(provider, count)
+	if state.current == from {
+		state.current = to
+	}
+}
+
+// isKeyExhausted checks if a request failed because its key is rate limited,
+// out of quota or rejected
+func isKeyExhausted(err error) bool {
+	status, ok := err.(errUnexpectedStatus)
+	return ok && (status.status == http.StatusTooManyRequests || status.status == http.StatusUnauthorized)
+}
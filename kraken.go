@@ -0,0 +1,139 @@
+package ticker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/health"
+)
+
+const (
+	krakenDefaultBaseURL = "https://api.kraken.com"
+	krakenTickerPath     = "/0/public/Ticker"
+)
+
+// krakenQuoteAssets are the assets Kraken pairs are quoted in, with the
+// legacy X/Z prefixed codes before the codes they end with
+var krakenQuoteAssets = []string{
+	"ZUSD", "ZEUR", "ZGBP", "ZCAD", "ZJPY", "XXBT", "XETH",
+	"USDT", "USDC", "USD", "EUR", "GBP", "CAD", "JPY", "CHF", "AUD", "XBT", "ETH",
+}
+
+// krakenAssets maps Kraken's asset codes to ours where they differ
+var krakenAssets = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// krakenTickerResponse is the response of the Ticker endpoint. The a, b and c
+// arrays start with the ask, bid and last trade prices.
+type krakenTickerResponse struct {
+	Error  []string `json:"error"`
+	Result map[string]struct {
+		A []json.Number `json:"a"`
+		B []json.Number `json:"b"`
+		C []json.Number `json:"c"`
+	} `json:"result"`
+}
+
+// NewKrakenFetcher returns the rates of the given Kraken pairs, e.g. XBTUSD or
+// ETHXBT. Pairs that don't involve BTC, e.g. ETHUSD, are returned as cross
+// quotes used by cross triangulation.
+func NewKrakenFetcher(baseURL string, pairs []string) fetchFn {
+	return func(job *health.Job, client *http.Client) (exchangeRates, error) {
+		query := url.Values{"pair": {strings.Join(pairs, ",")}}
+		req, err := http.NewRequest("GET", baseURL+krakenTickerPath+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errUnexpectedStatus{"kraken", resp.StatusCode}
+		}
+
+		payload := &krakenTickerResponse{}
+		err = json.Unmarshal(body, payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload.Error) > 0 {
+			return nil, errProviderResponse{"kraken", strings.Join(payload.Error, ", ")}
+		}
+
+		rates := exchangeRates{}
+		for pair, ticker := range payload.Result {
+			base, quote, ok := parseKrakenPair(pair)
+			if !ok || len(ticker.A) == 0 || len(ticker.B) == 0 || len(ticker.C) == 0 {
+				continue
+			}
+			symbol, rate, ok, err := pairRate(base, quote, ticker.A[0], ticker.B[0], ticker.C[0])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				rates[symbol] = rate
+			}
+		}
+		return rates, nil
+	}
+}
+
+// parseKrakenPair splits a Kraken pair name, e.g. XXBTZUSD or ETHXBT, into
+// canonical base and quote symbols
+func parseKrakenPair(pair string) (string, string, bool) {
+	for _, quote := range krakenQuoteAssets {
+		if !strings.HasSuffix(pair, quote) || len(pair) == len(quote) {
+			continue
+		}
+		// Prefixed quotes only follow prefixed bases, e.g. XXBTZUSD, so
+		// XTZUSD is XTZ in USD rather than XT in ZUSD
+		base := strings.TrimSuffix(pair, quote)
+		if isKrakenPrefixedAsset(quote) && (len(base) != 4 || base[0] != 'X') {
+			continue
+		}
+		return krakenSymbol(base), krakenSymbol(quote), true
+	}
+	return "", "", false
+}
+
+// isKrakenPrefixedAsset checks if an asset code is one of Kraken's legacy X or
+// Z prefixed codes, e.g. XXBT or ZUSD
+func isKrakenPrefixedAsset(asset string) bool {
+	return len(asset) == 4 && (asset[0] == 'X' || asset[0] == 'Z')
+}
+
+// krakenSymbol returns the canonical symbol for a Kraken asset code. Kraken
+// prefixes its older crypto assets with X and fiat assets with Z.
+func krakenSymbol(asset string) string {
+	if isKrakenPrefixedAsset(asset) {
+		asset = asset[1:]
+	}
+	if symbol, ok := krakenAssets[asset]; ok {
+		asset = symbol
+	}
+	return CanonicalizeSymbol(asset)
+}
+
+// krakenAssetCode returns Kraken's unprefixed code for a canonical symbol
+func krakenAssetCode(symbol string) string {
+	for asset, canonical := range krakenAssets {
+		if canonical == symbol {
+			return asset
+		}
+	}
+	return symbol
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
 
 	ticker "github.com/OpenBazaar/tickerproxy"
@@ -8,39 +10,111 @@ import (
 	"github.com/gocraft/health"
 )
 
-func main() {
-	lambda.Start(Fetch)
+// Event holds optional overrides for a single invocation
+type Event struct {
+	// DryRun fetches and validates rates without writing anything
+	DryRun bool `json:"dry_run"`
+
+	// Providers limits fetching to the named providers
+	Providers []string `json:"providers"`
+
+	// Symbols limits the published documents to these symbols
+	Symbols []string `json:"symbols"`
+
+	// BypassCache ignores cached provider responses
+	BypassCache bool `json:"bypass_cache"`
 }
 
-func Fetch() {
-	conf := ticker.NewConfig()
+// Result is returned from each invocation
+type Result struct {
+	DryRun bool `json:"dry_run"`
+	*ticker.FetchResult
+}
 
-	stream := health.NewStream()
-	stream.AddSink(&health.WriterSink{Writer: os.Stdout})
+var (
+	baseConf ticker.Config
+	stream   *health.Stream
+	setupErr error
+)
 
-	kvs := map[string]string{
-		"region":       conf.AWSS3Region,
-		"bucket":       conf.AWSS3Bucket,
-		"btcAvgPubKey": conf.BTCAVGPubkey,
+func main() {
+	baseConf, stream, setupErr = setup()
+	if setupErr != nil {
+		log.Println(setupErr)
 	}
+	lambda.Start(Handle)
+}
 
-	writer, err := ticker.NewS3Writer(conf.AWSS3Region, conf.AWSS3Bucket)
+// setup loads the config and creates the health stream once per container so
+// sinks aren't recreated on every invocation
+func setup() (ticker.Config, *health.Stream, error) {
+	conf, err := ticker.LoadConfig(os.Getenv("TICKER_CONFIG_PATH"))
+	if err == nil {
+		err = conf.ResolveSecrets()
+	}
+	if err == nil {
+		err = conf.Validate()
+	}
 	if err != nil {
-		stream.EventErrKv("new_s3_writer", err, kvs)
-		os.Exit(1)
+		return conf, nil, err
 	}
 
-	err = ticker.Fetch(stream, conf, writer)
+	// The Lambda filesystem is read-only outside /tmp, so only write locally
+	// when an output path other than the CLI default is configured
+	if conf.OutPath != "" && conf.OutPath == ticker.DefaultConfig().OutPath {
+		log.Printf("Not writing to the default out_path %q; set out_path to a writable directory such as /tmp to write locally", conf.OutPath)
+		conf.OutPath = ""
+	}
+
+	stream, err := ticker.NewHealthStream(conf)
 	if err != nil {
-		stream.EventErrKv("new_s3_writer", err, kvs)
-		os.Exit(1)
+		return conf, nil, err
 	}
+	return conf, stream, nil
 }
 
-func getEnvString(key string, defaultVal string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		val = defaultVal
+// Handle fetches rates with the overrides from the event and writes them with
+// the writers selected by the config
+func Handle(ctx context.Context, event Event) (*Result, error) {
+	if setupErr != nil {
+		return nil, setupErr
+	}
+
+	conf := baseConf
+	if len(event.Providers) > 0 {
+		conf.Providers = event.Providers
+	}
+	if len(event.Symbols) > 0 {
+		conf.Symbols = event.Symbols
+	}
+	if event.BypassCache {
+		conf.Cache.Bypass = true
+	}
+	err := conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := map[string]string{
+		"region": conf.AWSS3Region,
+		"bucket": conf.AWSS3Bucket,
+	}
+
+	err = ticker.ApplySymbolPolicy(conf)
+	if err != nil {
+		stream.EventErrKv("load_symbol_policy", err, kvs)
+		return nil, err
 	}
-	return val
+
+	writers := []ticker.NamedWriter{}
+	if !event.DryRun {
+		writers, err = ticker.NewWriters(conf)
+		if err != nil {
+			stream.EventErrKv("new_writers", err, kvs)
+			return nil, err
+		}
+	}
+
+	result, err := ticker.FetchWithResult(stream, conf, writers...)
+	return &Result{DryRun: event.DryRun, FetchResult: result}, err
 }
@@ -0,0 +1,194 @@
+package ticker
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gocraft/health"
+)
+
+// Market data fields that market rules can check
+const (
+	MarketFieldRank             = "rank"
+	MarketFieldVolume24hUSD     = "volume_24h_usd"
+	MarketFieldMarketCapUSD     = "market_cap_usd"
+	MarketFieldPercentChange1h  = "percent_change_1h"
+	MarketFieldPercentChange24h = "percent_change_24h"
+	MarketFieldPercentChange7d  = "percent_change_7d"
+)
+
+var marketFields = []string{
+	MarketFieldRank,
+	MarketFieldVolume24hUSD,
+	MarketFieldMarketCapUSD,
+	MarketFieldPercentChange1h,
+	MarketFieldPercentChange24h,
+	MarketFieldPercentChange7d,
+}
+
+// marketData describes a coin's market as reported by CMC. Volume and market
+// cap are in BTC; their USD values are filled in from the merged USD rate.
+// Missing values are nil.
+type marketData struct {
+	Rank             int      `json:"rank,omitempty"`
+	Volume24hBTC     *float64 `json:"volume_24h_btc,omitempty"`
+	MarketCapBTC     *float64 `json:"market_cap_btc,omitempty"`
+	Volume24hUSD     *float64 `json:"volume_24h_usd,omitempty"`
+	MarketCapUSD     *float64 `json:"market_cap_usd,omitempty"`
+	PercentChange1h  *float64 `json:"percent_change_1h,omitempty"`
+	PercentChange24h *float64 `json:"percent_change_24h,omitempty"`
+	PercentChange7d  *float64 `json:"percent_change_7d,omitempty"`
+}
+
+// empty checks if none of the market data is known
+func (m *marketData) empty() bool {
+	return *m == marketData{}
+}
+
+// field returns the value of a market field, if it is known
+func (m *marketData) field(name string) (float64, bool) {
+	if m == nil {
+		return 0, false
+	}
+
+	var value *float64
+	switch name {
+	case MarketFieldRank:
+		if m.Rank == 0 {
+			return 0, false
+		}
+		return float64(m.Rank), true
+	case MarketFieldVolume24hUSD:
+		value = m.Volume24hUSD
+	case MarketFieldMarketCapUSD:
+		value = m.MarketCapUSD
+	case MarketFieldPercentChange1h:
+		value = m.PercentChange1h
+	case MarketFieldPercentChange24h:
+		value = m.PercentChange24h
+	case MarketFieldPercentChange7d:
+		value = m.PercentChange7d
+	}
+	if value == nil {
+		return 0, false
+	}
+	return *value, true
+}
+
+// priceMarketData fills in the USD volume and market cap of each rate with
+// market data from the USD rate. They are left out when there is no USD rate.
+func priceMarketData(rates exchangeRates) {
+	usd, ok := rates["USD"]
+	if !ok {
+		return
+	}
+	usdPerBTC, err := usd.Last.Float64()
+	if err != nil || usdPerBTC <= 0 {
+		return
+	}
+
+	for symbol, rate := range rates {
+		if rate.Market == nil {
+			continue
+		}
+		market := *rate.Market
+		market.Volume24hUSD = multiplyMarketValue(market.Volume24hBTC, usdPerBTC)
+		market.MarketCapUSD = multiplyMarketValue(market.MarketCapBTC, usdPerBTC)
+		rate.Market = &market
+		rates[symbol] = rate
+	}
+}
+
+func multiplyMarketValue(value *float64, factor float64) *float64 {
+	if value == nil {
+		return nil
+	}
+	product := *value * factor
+	return &product
+}
+
+func validateMarketField(name string) error {
+	if !containsString(marketFields, name) {
+		return fmt.Errorf("unknown market field %q", name)
+	}
+	return nil
+}
+
+// MarketFloors excludes illiquid coins from the published documents. Coins
+// whose market data is below a floor are filtered out, unless they are required
+// or allowed. Floors left at 0 are off, and coins without the data a floor
+// checks, e.g. from providers other than CMC, are kept.
+type MarketFloors struct {
+	MinMarketCapUSD float64 `json:"min_market_cap_usd,omitempty"`
+	MinVolume24hUSD float64 `json:"min_volume_24h_usd,omitempty"`
+
+	// MaxRank is the lowest CMC rank kept, e.g. 500 keeps the top 500 coins
+	MaxRank int `json:"max_rank,omitempty"`
+
+	// Allow are symbols kept regardless of the floors
+	Allow []string `json:"allow,omitempty"`
+}
+
+// enabled checks if any floor is set
+func (f MarketFloors) enabled() bool {
+	return f.MinMarketCapUSD > 0 || f.MinVolume24hUSD > 0 || f.MaxRank > 0
+}
+
+// validate checks that the floors are well formed
+func (f MarketFloors) validate() error {
+	if f.MinMarketCapUSD < 0 || f.MinVolume24hUSD < 0 || f.MaxRank < 0 {
+		return fmt.Errorf("market floors must not be negative")
+	}
+	for _, symbol := range f.Allow {
+		if symbol == "" {
+			return fmt.Errorf("market floor allow list must not contain empty symbols")
+		}
+	}
+	return nil
+}
+
+// check returns why the market data is below a floor, or an empty string if it
+// isn't
+func (f MarketFloors) check(market *marketData) string {
+	if value, ok := market.field(MarketFieldRank); ok && f.MaxRank > 0 && value > float64(f.MaxRank) {
+		return fmt.Sprintf("%s %v is above %v", MarketFieldRank, value, f.MaxRank)
+	}
+	if value, ok := market.field(MarketFieldMarketCapUSD); ok && value < f.MinMarketCapUSD {
+		return fmt.Sprintf("%s %v is below %v", MarketFieldMarketCapUSD, value, f.MinMarketCapUSD)
+	}
+	if value, ok := market.field(MarketFieldVolume24hUSD); ok && value < f.MinVolume24hUSD {
+		return fmt.Sprintf("%s %v is below %v", MarketFieldVolume24hUSD, value, f.MinVolume24hUSD)
+	}
+	return ""
+}
+
+// applyMarketFloors removes the rates whose market data is below the floors and
+// returns the reason for each removed symbol. Required and allowed symbols and
+// rates set by overrides are always kept.
+func applyMarketFloors(job *health.Job, floors MarketFloors, rates exchangeRates) map[string]string {
+	filtered := map[string]string{}
+	if !floors.enabled() {
+		return filtered
+	}
+
+	kept := map[string]bool{}
+	for _, symbol := range CurrentSymbolPolicy().Required() {
+		kept[symbol] = true
+	}
+	for _, symbol := range floors.Allow {
+		kept[CanonicalizeSymbol(symbol)] = true
+	}
+
+	for symbol, rate := range rates {
+		if kept[symbol] || rate.Market == nil || rate.Override != nil {
+			continue
+		}
+		if reason := floors.check(rate.Market); reason != "" {
+			delete(rates, symbol)
+			filtered[symbol] = reason
+		}
+	}
+
+	job.EventKv("filter.market_floors", health.Kvs{"filtered": strconv.Itoa(len(filtered))})
+	return filtered
+}
@@ -0,0 +1,175 @@
+package ticker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestMarketRule(t *testing.T) {
+	volume := 2.0
+	change := -12.5
+	rates := exchangeRates{
+		"USD": {Ask: "10000", Bid: "10000", Last: "10000", Type: "fiat"},
+		"FOO": {Ask: "1", Bid: "1", Last: "1", Type: "crypto", Market: &marketData{Rank: 50, Volume24hBTC: &volume, PercentChange24h: &change}},
+		"BAR": {Ask: "1", Bid: "1", Last: "1", Type: "crypto"},
+	}
+	priceMarketData(rates)
+	if usd := rates["FOO"].Market.Volume24hUSD; usd == nil || *usd != 20000 {
+		t.Fatal("Incorrect USD volume:", usd)
+	}
+
+	for _, test := range []struct {
+		rule    ValidationRule
+		problem string
+	}{
+		{ValidationRule{Type: RuleMarket, Field: MarketFieldVolume24hUSD, Min: 10000}, ""},
+		{ValidationRule{Type: RuleMarket, Field: MarketFieldVolume24hUSD, Min: 50000}, "volume_24h_usd 20000 is below 50000"},
+		{ValidationRule{Type: RuleMarket, Field: MarketFieldRank, Max: 10}, "rank 50 is above 10"},
+		{ValidationRule{Type: RuleMarket, Field: MarketFieldPercentChange24h, Min: -10}, "percent_change_24h -12.5 is below -10"},
+		{ValidationRule{Type: RuleMarket, Field: MarketFieldMarketCapUSD, Min: 1}, ""},
+	} {
+		if problem := test.rule.check(rates["FOO"], exchangeRate{}, false); problem != test.problem {
+			t.Fatalf("Incorrect problem for %s: %q", test.rule.Field, problem)
+		}
+		if problem := test.rule.check(rates["BAR"], exchangeRate{}, false); problem != "" {
+			t.Fatal("Expected a rate without market data to pass:", problem)
+		}
+	}
+
+	invalid := []ValidationRule{
+		{Type: RuleMarket, Action: RuleActionDrop, Field: "liquidity", Min: 1},
+		{Type: RuleMarket, Action: RuleActionDrop, Field: MarketFieldRank},
+	}
+	for _, rule := range invalid {
+		if rule.validate() == nil {
+			t.Fatal("Expected an invalid market rule:", rule)
+		}
+	}
+}
+
+func TestFetchMarketData(t *testing.T) {
+	server := httptest.NewServer(fakeprovider.New(1, 12))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.CMCBaseURL = server.URL
+	conf.Providers = []string{"btcavg", "cmc"}
+	conf.ValidationRules = append(conf.ValidationRules, ValidationRule{
+		Type:   RuleMarket,
+		Action: RuleActionDrop,
+		Field:  MarketFieldVolume24hUSD,
+		Min:    10000,
+	})
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := NewMemoryWriter()
+	result, err := FetchWithResult(health.NewStream(), conf, NamedWriter{Name: "memory", Writer: writer.Write})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Dropped == 0 {
+		t.Fatal("Expected coins with little volume to be dropped")
+	}
+
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(writer.artifacts["api_v2"].Data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth := extended["ETH"].Market
+	if eth == nil || eth.Rank != 2 || eth.Volume24hBTC == nil || eth.Volume24hUSD == nil || eth.PercentChange7d == nil {
+		t.Fatal("Missing market data:", eth)
+	}
+	for symbol, rate := range extended {
+		if rate.Market != nil && rate.Market.Volume24hUSD != nil && *rate.Market.Volume24hUSD < 10000 {
+			t.Fatal("Expected to be dropped:", symbol)
+		}
+	}
+	if extended["USD"].Market != nil {
+		t.Fatal("Unexpected market data for a fiat currency:", extended["USD"].Market)
+	}
+}
+
+func TestMarketFloors(t *testing.T) {
+	marketCap, volume := 5e6, 2e4
+	market := &marketData{Rank: 120, MarketCapUSD: &marketCap, Volume24hUSD: &volume}
+	for _, test := range []struct {
+		floors MarketFloors
+		reason string
+	}{
+		{MarketFloors{}, ""},
+		{MarketFloors{MaxRank: 200, MinMarketCapUSD: 1e6, MinVolume24hUSD: 1e4}, ""},
+		{MarketFloors{MaxRank: 100}, "rank 120 is above 100"},
+		{MarketFloors{MinMarketCapUSD: 1e7}, "market_cap_usd 5e+06 is below 1e+07"},
+		{MarketFloors{MinVolume24hUSD: 1e5}, "volume_24h_usd 20000 is below 100000"},
+	} {
+		if reason := test.floors.check(market); reason != test.reason {
+			t.Fatalf("Incorrect reason for %+v: %q", test.floors, reason)
+		}
+	}
+	if reason := (MarketFloors{MaxRank: 1, MinVolume24hUSD: 1}).check(&marketData{}); reason != "" {
+		t.Fatal("Expected missing market data to pass:", reason)
+	}
+
+	if (MarketFloors{MaxRank: -1}).validate() == nil {
+		t.Fatal("Expected negative floors to be invalid")
+	}
+}
+
+func TestFetchMarketFloors(t *testing.T) {
+	server := httptest.NewServer(fakeprovider.New(1, 12))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.CMCBaseURL = server.URL
+	conf.Providers = []string{"btcavg", "cmc"}
+	conf.MarketFloors = MarketFloors{MaxRank: 4, Allow: []string{"XMR"}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := NewMemoryWriter()
+	result, err := FetchWithResult(health.NewStream(), conf, NamedWriter{Name: "memory", Writer: writer.Write})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates := exchangeRates{}
+	err = json.Unmarshal(writer.artifacts["api"].Data, &rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ZEC is required and XMR is allowed, so both are kept despite their rank
+	for _, symbol := range []string{"ETH", "LTC", "ZEC", "XMR", "USD"} {
+		if _, ok := rates[symbol]; !ok {
+			t.Fatal("Expected to be kept:", symbol)
+		}
+	}
+	for _, symbol := range []string{"DASH", "DOGE", "FAKE1"} {
+		if _, ok := rates[symbol]; ok {
+			t.Fatal("Expected to be filtered:", symbol)
+		}
+	}
+
+	status := Status{}
+	err = json.Unmarshal(writer.artifacts["status"].Data, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Filtered["DASH"] != "rank 7 is above 4" {
+		t.Fatal("Incorrect filter reason for DASH:", status.Filtered["DASH"])
+	}
+	if result.Filtered != len(status.Filtered) || result.Filtered != 14 {
+		t.Fatal("Incorrect number of filtered symbols:", result.Filtered)
+	}
+}
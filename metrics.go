@@ -0,0 +1,235 @@
+package ticker
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+// prometheusLabelKeys are the event kvs that are exported as metric labels.
+// Other kvs are left out to keep the number of series bounded.
+var prometheusLabelKeys = []string{"provider", "writer", "type"}
+
+// prometheusBuckets are the upper bounds in seconds of the duration histograms
+var prometheusBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var prometheusNameSanitizer = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// PrometheusSink is a health.Sink that aggregates the stream into Prometheus
+// metrics and serves them in the text exposition format:
+//
+//   - ticker_events_total and ticker_errors_total count events and errors
+//   - timings become ticker_<event>_duration_seconds histograms
+//   - gauges become ticker_<event> gauges
+//   - completed jobs are counted in ticker_job_runs_total, timed in
+//     ticker_job_duration_seconds and successful ones set
+//     ticker_job_last_success_timestamp_seconds
+type PrometheusSink struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	gauges     map[string]map[string]float64
+	histograms map[string]map[string]*prometheusHistogram
+	help       map[string]string
+}
+
+type prometheusHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusSink creates an empty PrometheusSink
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		counters:   map[string]map[string]float64{},
+		gauges:     map[string]map[string]float64{},
+		histograms: map[string]map[string]*prometheusHistogram{},
+		help:       map[string]string{},
+	}
+}
+
+// EmitEvent counts the event
+func (s *PrometheusSink) EmitEvent(job string, event string, kvs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addCounter("ticker_events_total", "Number of events emitted.", prometheusLabels(job, event, kvs), 1)
+}
+
+// EmitEventErr counts the error by the event it happened in
+func (s *PrometheusSink) EmitEventErr(job string, event string, err error, kvs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addCounter("ticker_errors_total", "Number of errors by the event they happened in.", prometheusLabels(job, event, kvs), 1)
+}
+
+// EmitTiming records the duration in a histogram named after the event
+func (s *PrometheusSink) EmitTiming(job string, event string, nanos int64, kvs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := "ticker_" + sanitizePrometheusName(event) + "_duration_seconds"
+	s.observe(name, "Duration of "+event+" in seconds.", prometheusLabels(job, "", kvs), float64(nanos)/float64(time.Second))
+}
+
+// EmitGauge sets a gauge named after the event
+func (s *PrometheusSink) EmitGauge(job string, event string, value float64, kvs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setGauge("ticker_"+sanitizePrometheusName(event), "Last reported value of "+event+".", prometheusLabels(job, "", kvs), value)
+}
+
+// EmitComplete counts and times the job, and records when it last succeeded
+func (s *PrometheusSink) EmitComplete(job string, status health.CompletionStatus, nanos int64, kvs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobLabels := prometheusLabels(job, "", nil)
+	s.addCounter("ticker_job_runs_total", "Number of completed jobs by status.", jobLabels+`,status="`+status.String()+`"`, 1)
+	s.observe("ticker_job_duration_seconds", "Duration of completed jobs in seconds.", jobLabels, float64(nanos)/float64(time.Second))
+	if status == health.Success {
+		s.setGauge("ticker_job_last_success_timestamp_seconds", "Unix time the job last completed successfully.", jobLabels, float64(time.Now().UnixNano())/float64(time.Second))
+	}
+}
+
+func (s *PrometheusSink) addCounter(name string, help string, labels string, value float64) {
+	if s.counters[name] == nil {
+		s.counters[name] = map[string]float64{}
+		s.help[name] = help
+	}
+	s.counters[name][labels] += value
+}
+
+func (s *PrometheusSink) setGauge(name string, help string, labels string, value float64) {
+	if s.gauges[name] == nil {
+		s.gauges[name] = map[string]float64{}
+		s.help[name] = help
+	}
+	s.gauges[name][labels] = value
+}
+
+func (s *PrometheusSink) observe(name string, help string, labels string, value float64) {
+	if s.histograms[name] == nil {
+		s.histograms[name] = map[string]*prometheusHistogram{}
+		s.help[name] = help
+	}
+	h := s.histograms[name][labels]
+	if h == nil {
+		h = &prometheusHistogram{counts: make([]uint64, len(prometheusBuckets))}
+		s.histograms[name][labels] = h
+	}
+	for i, bound := range prometheusBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (s *PrometheusSink) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rw.Write(s.render())
+}
+
+func (s *PrometheusSink) render() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+	writeHeader := func(name string, metricType string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, s.help[name], name, metricType)
+	}
+
+	for _, name := range sortedKeys(s.counters) {
+		writeHeader(name, "counter")
+		for _, labels := range sortedLabels(s.counters[name]) {
+			fmt.Fprintf(buf, "%s{%s} %s\n", name, labels, formatPrometheusValue(s.counters[name][labels]))
+		}
+	}
+
+	for _, name := range sortedKeys(s.gauges) {
+		writeHeader(name, "gauge")
+		for _, labels := range sortedLabels(s.gauges[name]) {
+			fmt.Fprintf(buf, "%s{%s} %s\n", name, labels, formatPrometheusValue(s.gauges[name][labels]))
+		}
+	}
+
+	histogramNames := make([]string, 0, len(s.histograms))
+	for name := range s.histograms {
+		histogramNames = append(histogramNames, name)
+	}
+	sort.Strings(histogramNames)
+	for _, name := range histogramNames {
+		writeHeader(name, "histogram")
+		series := s.histograms[name]
+		labelSets := make([]string, 0, len(series))
+		for labels := range series {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+
+		for _, labels := range labelSets {
+			h := series[labels]
+			for i, bound := range prometheusBuckets {
+				fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatPrometheusValue(bound), h.counts[i])
+			}
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+			fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, formatPrometheusValue(h.sum))
+			fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// prometheusLabels builds the label string for a series. The event label is
+// left out when it is empty.
+func prometheusLabels(job string, event string, kvs map[string]string) string {
+	labels := []string{`job="` + escapePrometheusLabel(job) + `"`}
+	if event != "" {
+		labels = append(labels, `event="`+escapePrometheusLabel(event)+`"`)
+	}
+	for _, key := range prometheusLabelKeys {
+		if value, ok := kvs[key]; ok {
+			labels = append(labels, key+`="`+escapePrometheusLabel(value)+`"`)
+		}
+	}
+	return strings.Join(labels, ",")
+}
+
+func escapePrometheusLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func sanitizePrometheusName(name string) string {
+	return prometheusNameSanitizer.ReplaceAllString(name, "_")
+}
+
+func formatPrometheusValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedLabels(series map[string]float64) []string {
+	labelSets := make([]string, 0, len(series))
+	for labels := range series {
+		labelSets = append(labelSets, labels)
+	}
+	sort.Strings(labelSets)
+	return labelSets
+}
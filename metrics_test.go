@@ -0,0 +1,43 @@
+package ticker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink()
+	stream := health.NewStream()
+	stream.AddSink(sink)
+
+	job := stream.NewJob("fetch")
+	job.TimingKv("fetch_provider", int64(300*time.Millisecond), health.Kvs{"provider": "cmc", "ignored": "x"})
+	job.GaugeKv("symbols", 42, health.Kvs{"type": "crypto"})
+	job.EventErrKv("fetch_data", errors.New("boom"), health.Kvs{"provider": "btcavg"})
+	job.EventErrKv("fetch_data", errors.New("boom"), health.Kvs{"provider": "btcavg"})
+	job.Complete(health.Success)
+
+	metrics := string(sink.render())
+	for _, line := range []string{
+		"# TYPE ticker_fetch_provider_duration_seconds histogram",
+		`ticker_fetch_provider_duration_seconds_bucket{job="fetch",provider="cmc",le="0.25"} 0`,
+		`ticker_fetch_provider_duration_seconds_bucket{job="fetch",provider="cmc",le="0.5"} 1`,
+		`ticker_fetch_provider_duration_seconds_count{job="fetch",provider="cmc"} 1`,
+		`ticker_symbols{job="fetch",type="crypto"} 42`,
+		`ticker_errors_total{job="fetch",event="fetch_data",provider="btcavg"} 2`,
+		`ticker_job_runs_total{job="fetch",status="success"} 1`,
+		`ticker_job_last_success_timestamp_seconds{job="fetch"} `,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Fatal("Missing metric line:", line, "\n", metrics)
+		}
+	}
+
+	if strings.Contains(metrics, "ignored") {
+		t.Fatal("Unexpected label in metrics:\n", metrics)
+	}
+}
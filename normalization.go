@@ -1,116 +1,355 @@
 package ticker
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocraft/health"
 )
 
-// RequiredSymbols are symbols we expect to be present and their absence should
-// be treated as an error.
-var RequiredSymbols = []string{
-	"USD",
-	"EUR",
-	"GBP",
-	"CAD",
-	"RUB",
-	"BRL",
-	"AUD",
-	"BGN",
-	"NOK",
-	"CZK",
-
-	"BTC",
-	"BCH",
-	"ZEC",
-	"ETH",
-}
-
-// AltSymbolsToCanonicalSymbols maps symbols that may be used in some sources to
-// represent coins that we use a different symbol for
-var AltSymbolsToCanonicalSymbols = map[string]string{
-	"IOTA": "MIOTA",
-}
-
-// PinnedSymbolsToIDs maps symbols that may be used by multiple coins to a
-// single coin by its CMC IDs.
-var PinnedSymbolsToIDs = map[string]int64{
-	"BTC":  1,    // Bitcoin
-	"LTC":  2,    // Litecoin
-	"NXT":  66,   // Nxt
-	"DOGE": 74,   // Dogecoin
-	"DASH": 131,  // Dash
-	"XMR":  328,  // Monero
-	"ETH":  1027, // Ethereum
-	"ZEC":  1437, // Zcash
-	"BCH":  1831, // Bitcoin Cash
-
-	"BTG":  2083, // Bitcoin Gold
-	"CMT":  2246, // CyberMiles
-	"KNC":  1982, // Kyber Network
-	"BTM":  1866, // Bytom
-	"ICN":  1408, // Iconomi
-	"GTC":  2336, // Game.com
-	"BLZ":  2505, // Bluzelle
-	"HOT":  2682, // Holo
-	"RCN":  2096, // Ripio Credit Network
-	"FAIR": 224,  // FairCoin
-	"EDR":  2835, // Endor Protocol
-	"CPC":  2482, // CPChain
-	"QBT":  2242, // Qbao
-	"KEY":  2398, // Selfkey
-	"RED":  2771, // RED
-	"HMC":  2484, // Hi Mutual Society
-	"NET":  1811, // Nimiq Exchange Token
-	"LNC":  2677, // Linker Coin
-	"CAN":  2343, // CanYaCoin
-	"BET":  1771, // DAO.Casino
-	"SPD":  2616, // Stipend
-	"CAT":  2334, // BitClave
-	"GCC":  1531, // Global Cryptocurrency
-	"PUT":  2419, // Profile Utility Token
-	"MAG":  2218, // Magnet
-	"CRC":  2664, // CryCash
-	"ACC":  2225, // Accelerator Network
-	"PXC":  35,   // Phoenixcoin
-	"ETT":  1714, // EncryptoTel [WAVES]
-	"XIN":  2349, // Mixin
-	"HERO": 1805, // Sovereign Hero
-	"HNC":  1004, // Helleniccoin
-	"ENT":  1474, // Eternity
-	"LBTC": 1825, // LiteBitcoin
-	"CMS":  2262, // COMSA [ETH]
-}
-
-var pinnedSymbolsToIDsJSON []byte
-
-// PinnedSymbolsToIDsJSON returns the PinnedSymbolsToIDs marshaled to JSON
-func PinnedSymbolsToIDsJSON() []byte {
-	return pinnedSymbolsToIDsJSON
+// SymbolPolicy controls which symbols are published and how symbols from the
+// sources are resolved to the ones we publish.
+type SymbolPolicy struct {
+	// RequiredFiat and RequiredCrypto are symbols we expect to be present and
+	// their absence should be treated as an error.
+	RequiredFiat   []string `json:"required_fiat"`
+	RequiredCrypto []string `json:"required_crypto"`
+
+	// Pinned maps symbols that may be used by multiple coins to a single coin
+	// by its CMC IDs.
+	Pinned map[string]int64 `json:"pinned"`
+
+	// Aliases maps symbols that may be used in some sources to represent coins
+	// that we use a different symbol for.
+	Aliases map[string]string `json:"aliases"`
+
+	// BannedCrypto are crypto symbols that we don't want included in the API.
+	BannedCrypto []string `json:"banned_crypto"`
+}
+
+// DefaultSymbolPolicy returns the policy used when no policy file is configured
+func DefaultSymbolPolicy() SymbolPolicy {
+	return SymbolPolicy{
+		RequiredFiat: []string{
+			"USD",
+			"EUR",
+			"GBP",
+			"CAD",
+			"RUB",
+			"BRL",
+			"AUD",
+			"BGN",
+			"NOK",
+			"CZK",
+		},
+		RequiredCrypto: []string{
+			"BTC",
+			"BCH",
+			"ZEC",
+			"ETH",
+		},
+		Pinned: map[string]int64{
+			"BTC":  1,    // Bitcoin
+			"LTC":  2,    // Litecoin
+			"NXT":  66,   // Nxt
+			"DOGE": 74,   // Dogecoin
+			"DASH": 131,  // Dash
+			"XMR":  328,  // Monero
+			"ETH":  1027, // Ethereum
+			"ZEC":  1437, // Zcash
+			"BCH":  1831, // Bitcoin Cash
+
+			"BTG":  2083, // Bitcoin Gold
+			"CMT":  2246, // CyberMiles
+			"KNC":  1982, // Kyber Network
+			"BTM":  1866, // Bytom
+			"ICN":  1408, // Iconomi
+			"GTC":  2336, // Game.com
+			"BLZ":  2505, // Bluzelle
+			"HOT":  2682, // Holo
+			"RCN":  2096, // Ripio Credit Network
+			"FAIR": 224,  // FairCoin
+			"EDR":  2835, // Endor Protocol
+			"CPC":  2482, // CPChain
+			"QBT":  2242, // Qbao
+			"KEY":  2398, // Selfkey
+			"RED":  2771, // RED
+			"HMC":  2484, // Hi Mutual Society
+			"NET":  1811, // Nimiq Exchange Token
+			"LNC":  2677, // Linker Coin
+			"CAN":  2343, // CanYaCoin
+			"BET":  1771, // DAO.Casino
+			"SPD":  2616, // Stipend
+			"CAT":  2334, // BitClave
+			"GCC":  1531, // Global Cryptocurrency
+			"PUT":  2419, // Profile Utility Token
+			"MAG":  2218, // Magnet
+			"CRC":  2664, // CryCash
+			"ACC":  2225, // Accelerator Network
+			"PXC":  35,   // Phoenixcoin
+			"ETT":  1714, // EncryptoTel [WAVES]
+			"XIN":  2349, // Mixin
+			"HERO": 1805, // Sovereign Hero
+			"HNC":  1004, // Helleniccoin
+			"ENT":  1474, // Eternity
+			"LBTC": 1825, // LiteBitcoin
+			"CMS":  2262, // COMSA [ETH]
+		},
+		Aliases: map[string]string{
+			"IOTA": "MIOTA",
+		},
+		BannedCrypto: []string{
+			"USD",
+		},
+	}
 }
 
+var (
+	symbolPolicyMu         sync.RWMutex
+	symbolPolicy           SymbolPolicy
+	bannedSymbols          map[string]struct{}
+	pinnedSymbolsToIDsJSON []byte
+)
+
 func init() {
-	var err error
-	pinnedSymbolsToIDsJSON, err = json.Marshal(&PinnedSymbolsToIDs)
+	err := SetSymbolPolicy(DefaultSymbolPolicy())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// CurrentSymbolPolicy returns the policy currently in use
+func CurrentSymbolPolicy() SymbolPolicy {
+	symbolPolicyMu.RLock()
+	defer symbolPolicyMu.RUnlock()
+	return symbolPolicy
+}
+
+// SetSymbolPolicy validates the given policy and makes it the current one
+func SetSymbolPolicy(policy SymbolPolicy) error {
+	err := policy.Validate()
+	if err != nil {
+		return err
+	}
+
+	pinnedJSON, err := json.Marshal(policy.Pinned)
+	if err != nil {
+		return err
+	}
+
+	banned := make(map[string]struct{}, len(policy.BannedCrypto))
+	for _, symbol := range policy.BannedCrypto {
+		banned[symbol] = struct{}{}
+	}
+
+	symbolPolicyMu.Lock()
+	defer symbolPolicyMu.Unlock()
+	symbolPolicy = policy
+	bannedSymbols = banned
+	pinnedSymbolsToIDsJSON = pinnedJSON
+	return nil
+}
+
+// LoadSymbolPolicy reads a policy from a local path or an s3://bucket/key URL
+func LoadSymbolPolicy(conf Config, location string) (SymbolPolicy, error) {
+	data, err := readResource(conf, location)
+	if err != nil {
+		return SymbolPolicy{}, err
+	}
+	return parseSymbolPolicy(data)
+}
+
+// ApplySymbolPolicy loads the policy configured in the Config, if any, and
+// makes it the current one
+func ApplySymbolPolicy(conf Config) error {
+	if conf.SymbolPolicyPath == "" {
+		return SetSymbolPolicy(DefaultSymbolPolicy())
+	}
+
+	policy, err := LoadSymbolPolicy(conf, conf.SymbolPolicyPath)
+	if err != nil {
+		return err
+	}
+	return SetSymbolPolicy(policy)
+}
+
+// WatchSymbolPolicy polls the configured policy location and applies the
+// policy whenever its contents change, until stop is closed. An invalid policy
+// is reported and the current one is kept.
+func WatchSymbolPolicy(stream *health.Stream, conf Config, interval time.Duration, stop <-chan struct{}) {
+	if conf.SymbolPolicyPath == "" {
+		return
+	}
+
+	kvs := health.Kvs{"location": conf.SymbolPolicyPath}
+	lastData, _ := readResource(conf, conf.SymbolPolicyPath)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		data, err := readResource(conf, conf.SymbolPolicyPath)
+		if err != nil {
+			stream.EventErrKv("symbol_policy.read", err, kvs)
+			continue
+		}
+		if bytes.Equal(data, lastData) {
+			continue
+		}
+		lastData = data
+
+		policy, err := parseSymbolPolicy(data)
+		if err == nil {
+			err = SetSymbolPolicy(policy)
+		}
+		if err != nil {
+			stream.EventErrKv("symbol_policy.reload", err, kvs)
+			continue
+		}
+		stream.EventKv("symbol_policy.reload", kvs)
+	}
+}
+
+func parseSymbolPolicy(data []byte) (SymbolPolicy, error) {
+	policy := SymbolPolicy{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policy)
 	if err != nil {
-		log.Fatalln(err)
+		return SymbolPolicy{}, err
+	}
+	return policy, nil
+}
+
+// Validate checks the policy for conflicting entries
+func (p SymbolPolicy) Validate() error {
+	problems := []string{}
+
+	banned := map[string]bool{}
+	for _, symbol := range p.BannedCrypto {
+		banned[symbol] = true
+	}
+
+	for _, symbol := range p.RequiredCrypto {
+		if banned[symbol] {
+			problems = append(problems, fmt.Sprintf("%s is both required and banned", symbol))
+		}
+	}
+
+	for _, symbol := range p.Required() {
+		if _, ok := p.Aliases[symbol]; ok {
+			problems = append(problems, fmt.Sprintf("%s is required but is an alias", symbol))
+		}
+	}
+
+	for symbol, id := range p.Pinned {
+		if banned[symbol] {
+			problems = append(problems, fmt.Sprintf("%s is both pinned and banned", symbol))
+		}
+		if _, ok := p.Aliases[symbol]; ok {
+			problems = append(problems, fmt.Sprintf("%s is pinned but is an alias", symbol))
+		}
+		if id <= 0 {
+			problems = append(problems, fmt.Sprintf("%s is pinned to invalid ID %d", symbol, id))
+		}
+	}
+
+	for alias, symbol := range p.Aliases {
+		if alias == symbol {
+			problems = append(problems, fmt.Sprintf("%s is an alias for itself", alias))
+		}
+		if _, ok := p.Aliases[symbol]; ok {
+			problems = append(problems, fmt.Sprintf("%s is an alias for %s which is also an alias", alias, symbol))
+		}
+		if banned[symbol] {
+			problems = append(problems, fmt.Sprintf("%s is an alias for banned symbol %s", alias, symbol))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errInvalidSymbolPolicy(strings.Join(problems, "; "))
 	}
+	return nil
+}
+
+// Required returns all required fiat and crypto symbols
+func (p SymbolPolicy) Required() []string {
+	return append(append([]string{}, p.RequiredFiat...), p.RequiredCrypto...)
+}
+
+// PinnedSymbolsToIDsJSON returns the pinned symbols of the current policy
+// marshaled to JSON
+func PinnedSymbolsToIDsJSON() []byte {
+	symbolPolicyMu.RLock()
+	defer symbolPolicyMu.RUnlock()
+	return pinnedSymbolsToIDsJSON
+}
+
+// pinnedCMCIDs returns the CMC IDs pinned by the current policy in ascending
+// order
+func pinnedCMCIDs() []int64 {
+	symbolPolicyMu.RLock()
+	defer symbolPolicyMu.RUnlock()
+	ids := make([]int64, 0, len(symbolPolicy.Pinned))
+	for _, id := range symbolPolicy.Pinned {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
 
 // CanonicalizeSymbol returns the canonical symbol from the given one, which may
 // or may not be a nickname
 func CanonicalizeSymbol(symbol string) string {
-	if canonicalSymbol, ok := AltSymbolsToCanonicalSymbols[symbol]; ok {
+	symbolPolicyMu.RLock()
+	defer symbolPolicyMu.RUnlock()
+	if canonicalSymbol, ok := symbolPolicy.Aliases[symbol]; ok {
 		symbol = canonicalSymbol
 	}
 	return symbol
 }
 
 // IsCorrectIDForSymbol checks if the given id is the correct one for the given
-// symbol based on the map `PinnedSymbolsToIDs`
+// symbol based on the pinned symbols of the current policy
 func IsCorrectIDForSymbol(symbol string, id int64) bool {
-	pinnedSymbolID, symbolHasDupes := PinnedSymbolsToIDs[symbol]
+	pinnedSymbolID, symbolHasDupes := pinnedIDForSymbol(symbol)
 	if !symbolHasDupes || pinnedSymbolID == id {
 		return true
 	}
 	return false
 }
+
+// pinnedIDForSymbol returns the CMC ID the given symbol is pinned to by the
+// current policy, if any
+func pinnedIDForSymbol(symbol string) (int64, bool) {
+	symbolPolicyMu.RLock()
+	defer symbolPolicyMu.RUnlock()
+	id, ok := symbolPolicy.Pinned[symbol]
+	return id, ok
+}
+
+// isBannedCryptoSymbol checks if the given crypto symbol is banned by the
+// current policy
+func isBannedCryptoSymbol(symbol string) bool {
+	symbolPolicyMu.RLock()
+	defer symbolPolicyMu.RUnlock()
+	_, ok := bannedSymbols[symbol]
+	return ok
+}
+
+type errInvalidSymbolPolicy string
+
+func (e errInvalidSymbolPolicy) Error() string {
+	return "Invalid symbol policy: " + string(e)
+}
@@ -0,0 +1,100 @@
+package ticker
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+func TestSymbolPolicyValidate(t *testing.T) {
+	err := DefaultSymbolPolicy().Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SymbolPolicy{
+		RequiredCrypto: []string{"ETH", "IOTA"},
+		Pinned:         map[string]int64{"ETH": 1027, "DOGE": 0},
+		Aliases:        map[string]string{"IOTA": "MIOTA", "XBT": "BTC", "BTC": "XBT"},
+		BannedCrypto:   []string{"ETH"},
+	}.Validate()
+	if err == nil {
+		t.Fatal("Expected conflicting policy to be invalid")
+	}
+
+	for _, problem := range []string{
+		"ETH is both required and banned",
+		"ETH is both pinned and banned",
+		"IOTA is required but is an alias",
+		"DOGE is pinned to invalid ID 0",
+		"XBT is an alias for BTC which is also an alias",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatal("Expected problem", problem, "in", err)
+		}
+	}
+}
+
+func TestApplySymbolPolicy(t *testing.T) {
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	policyFile, err := ioutil.TempFile("", "ticker_proxy_policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(policyFile.Name())
+
+	writePolicy := func(policy string) {
+		err := ioutil.WriteFile(policyFile.Name(), []byte(policy), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePolicy(`{"pinned": {"ACC": 2224}, "aliases": {"XBT": "BTC"}}`)
+	conf := Config{SymbolPolicyPath: policyFile.Name()}
+	err = ApplySymbolPolicy(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CanonicalizeSymbol("XBT") != "BTC" || CanonicalizeSymbol("IOTA") != "IOTA" {
+		t.Fatal("Aliases were not loaded from the policy file")
+	}
+	if string(PinnedSymbolsToIDsJSON()) != `{"ACC":2224}` {
+		t.Fatal("Incorrect pinned JSON:", string(PinnedSymbolsToIDsJSON()))
+	}
+
+	// Unknown keys are rejected
+	writePolicy(`{"banned": ["USD"]}`)
+	err = ApplySymbolPolicy(conf)
+	if err == nil {
+		t.Fatal("Expected unknown key error")
+	}
+
+	// Changes are picked up by the watcher; invalid changes are ignored
+	writePolicy(`{"pinned": {"ACC": 2224}}`)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		WatchSymbolPolicy(health.NewStream(), conf, 5*time.Millisecond, stop)
+		close(done)
+	}()
+
+	writePolicy(`{"pinned": {"ACC": 2224}, "banned_crypto": ["ACC"]}`)
+	time.Sleep(50 * time.Millisecond)
+	if !IsCorrectIDForSymbol("ACC", 2224) || isBannedCryptoSymbol("ACC") {
+		t.Fatal("Invalid policy was applied")
+	}
+
+	writePolicy(`{"pinned": {"ACC": 2225}}`)
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+	if !IsCorrectIDForSymbol("ACC", 2225) {
+		t.Fatal("Watcher did not reload the changed policy")
+	}
+}
@@ -0,0 +1,199 @@
+package ticker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+// Override actions
+const (
+	// OverrideFixed publishes a fixed price for the symbol
+	OverrideFixed = "fixed"
+
+	// OverrideFreeze publishes the symbol's last published rate
+	OverrideFreeze = "freeze"
+
+	// OverrideRemove leaves the symbol out
+	OverrideRemove = "remove"
+)
+
+// overrideSource is the source reported for fixed and frozen rates
+const overrideSource = "override"
+
+// Override replaces what runs publish for a symbol, e.g. during an incident
+type Override struct {
+	Symbol string `json:"symbol"`
+	Action string `json:"action"`
+
+	// Price is the rate of fixed overrides, in units of the symbol per BTC
+	Price json.Number `json:"price,omitempty"`
+
+	// Expires is when the override stops applying. Overrides without it apply
+	// until they are removed from the file.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Reason explains the override and is reported with it
+	Reason string `json:"reason"`
+}
+
+// overridesFile is the format of the overrides file
+type overridesFile struct {
+	Overrides []Override `json:"overrides"`
+}
+
+// validate checks that the override is well formed
+func (o Override) validate() error {
+	if o.Symbol == "" {
+		return fmt.Errorf("override symbol is required")
+	}
+	if o.Reason == "" {
+		return fmt.Errorf("%s override reason is required", o.Symbol)
+	}
+
+	switch o.Action {
+	case OverrideFixed:
+		if price, err := o.Price.Float64(); err != nil || price <= 0 {
+			return fmt.Errorf("%s fixed override price must be positive, got %q", o.Symbol, o.Price)
+		}
+	case OverrideFreeze:
+	case OverrideRemove:
+		if containsString(CurrentSymbolPolicy().Required(), CanonicalizeSymbol(o.Symbol)) {
+			return fmt.Errorf("%s is required and can't be removed", o.Symbol)
+		}
+	default:
+		return fmt.Errorf("unknown %s override action %q", o.Symbol, o.Action)
+	}
+	return nil
+}
+
+// active checks if the override applies at the given time
+func (o Override) active(now time.Time) bool {
+	return o.Expires == nil || now.Before(*o.Expires)
+}
+
+// parseOverrides parses and validates an overrides file. Each symbol may only
+// be overridden once.
+func parseOverrides(data []byte) ([]Override, error) {
+	file := overridesFile{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	seen := map[string]bool{}
+	for _, override := range file.Overrides {
+		if err := override.validate(); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		symbol := CanonicalizeSymbol(override.Symbol)
+		if seen[symbol] {
+			problems = append(problems, fmt.Sprintf("%s is overridden more than once", symbol))
+		}
+		seen[symbol] = true
+	}
+	if len(problems) > 0 {
+		return nil, errInvalidOverrides(strings.Join(problems, "; "))
+	}
+	return file.Overrides, nil
+}
+
+// LoadOverrides reads the overrides file configured in the Config. There are
+// none when no file is configured.
+func LoadOverrides(conf Config) ([]Override, error) {
+	if conf.OverridesPath == "" {
+		return nil, nil
+	}
+
+	data, err := readResource(conf, conf.OverridesPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseOverrides(data)
+}
+
+// hasOverrideAction checks if any of the overrides has the given action
+func hasOverrideAction(overrides []Override, action string) bool {
+	for _, override := range overrides {
+		if override.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// rateOverride flags an overridden rate in the extended document
+type rateOverride struct {
+	Action  string     `json:"action"`
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// applyOverrides applies the active overrides to the rates and returns the
+// action and reason for each overridden symbol. Frozen symbols take their rate
+// from the published rates and are removed if they have none. Fixed and frozen
+// rates keep the fetched rate's name and market data.
+func applyOverrides(job *health.Job, overrides []Override, rates exchangeRates, published exchangeRates, now time.Time) map[string]string {
+	overridden := map[string]string{}
+	for _, override := range overrides {
+		symbol := CanonicalizeSymbol(override.Symbol)
+		kvs := health.Kvs{"symbol": symbol, "action": override.Action}
+		if !override.active(now) {
+			job.EventKv("override.expired", kvs)
+			continue
+		}
+
+		overridden[symbol] = fmt.Sprintf("%s: %s", override.Action, override.Reason)
+		job.EventKv("override.apply", kvs)
+
+		var prices exchangeRate
+		switch override.Action {
+		case OverrideRemove:
+			delete(rates, symbol)
+			continue
+		case OverrideFixed:
+			prices = exchangeRate{Ask: override.Price, Bid: override.Price, Last: override.Price}
+		case OverrideFreeze:
+			var ok bool
+			prices, ok = published[symbol]
+			if !ok {
+				delete(rates, symbol)
+				overridden[symbol] += " (no published rate, removed)"
+				job.EventErrKv("override.apply", errInvalidOverrides("no published rate to freeze "+symbol), kvs)
+				continue
+			}
+		}
+
+		rate, ok := rates[symbol]
+		if !ok {
+			rate.Type = prices.Type
+			if rate.Type == "" {
+				rate.Type = exchangeRateTypeCrypto.String()
+				if _, ok := iso4217Currencies[symbol]; ok {
+					rate.Type = exchangeRateTypeFiat.String()
+				}
+			}
+		}
+		rate.Ask, rate.Bid, rate.Last = prices.Ask, prices.Bid, prices.Last
+		rate.Source = overrideSource
+		rate.Path, rate.Derived = nil, false
+		rate.Raw, rate.Smoothing = nil, ""
+		rate.Override = &rateOverride{Action: override.Action, Reason: override.Reason, Expires: override.Expires}
+		rates[symbol] = rate
+	}
+	return overridden
+}
+
+type errInvalidOverrides string
+
+func (e errInvalidOverrides) Error() string {
+	return "Invalid overrides: " + string(e)
+}
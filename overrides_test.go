@@ -0,0 +1,139 @@
+package ticker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := parseOverrides([]byte(`{"overrides": [
+		{"symbol": "GBP", "action": "fixed", "price": "20000", "reason": "feed incident"},
+		{"symbol": "FOO", "action": "remove", "expires": "2030-01-01T00:00:00Z", "reason": "delisted"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 2 || overrides[1].Expires == nil || !overrides[1].active(time.Now()) {
+		t.Fatal("Incorrect overrides:", overrides)
+	}
+	if overrides[1].active(time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("Expected the override to expire")
+	}
+
+	for _, data := range []string{
+		`{"overrides": [{"symbol": "GBP", "action": "fixed", "price": "20000"}]}`,
+		`{"overrides": [{"symbol": "GBP", "action": "fixed", "reason": "no price"}]}`,
+		`{"overrides": [{"symbol": "GBP", "action": "pause", "reason": "unknown action"}]}`,
+		`{"overrides": [{"symbol": "USD", "action": "remove", "reason": "required"}]}`,
+		`{"overrides": [{"symbol": "FOO", "action": "remove", "reason": "a"}, {"symbol": "FOO", "action": "freeze", "reason": "b"}]}`,
+		`{"overrides": [{"symbol": "FOO", "action": "remove", "reason": "a", "until": "tomorrow"}]}`,
+	} {
+		if _, err := parseOverrides([]byte(data)); err == nil {
+			t.Fatal("Expected invalid overrides:", data)
+		}
+	}
+}
+
+func TestFetchWithOverrides(t *testing.T) {
+	provider := fakeprovider.New(1, 0)
+	server := httptest.NewServer(provider)
+	defer server.Close()
+
+	outPath, err := ioutil.TempDir("", "ticker_proxy_overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outPath)
+
+	conf := DefaultConfig()
+	conf.OutPath = outPath
+	conf.BTCAVGBaseURL = server.URL
+	conf.Providers = []string{"btcavg"}
+	writer := NewFileSystemWriter(outPath)
+
+	err = Fetch(health.NewStream(), conf, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := loadPublishedRates(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yesterday := time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+	conf.OverridesPath = path.Join(outPath, "overrides.json")
+	err = ioutil.WriteFile(conf.OverridesPath, []byte(`{"overrides": [
+		{"symbol": "GBP", "action": "fixed", "price": "12345", "reason": "feed incident"},
+		{"symbol": "ETH", "action": "freeze", "reason": "provider outage"},
+		{"symbol": "JPY", "action": "remove", "reason": "bad quotes"},
+		{"symbol": "CHF", "action": "fixed", "price": "1", "expires": "`+yesterday+`", "reason": "expired"},
+		{"symbol": "FOO", "action": "freeze", "reason": "never published"}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.SetScenarios(fakeprovider.ScenarioRandomWalk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Fetch(health.NewStream(), conf, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates, err := loadPublishedRates(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["GBP"].Last != "12345" || rates["GBP"].Type != "fiat" {
+		t.Fatal("Expected a fixed GBP rate:", rates["GBP"])
+	}
+	// Random walks move ETH, so an unchanged rate is frozen
+	if rates["ETH"].Last != published["ETH"].Last || rates["ETH"].Ask != published["ETH"].Ask {
+		t.Fatal("Expected ETH to be frozen:", rates["ETH"], published["ETH"])
+	}
+	if _, ok := rates["JPY"]; ok {
+		t.Fatal("Expected JPY to be removed")
+	}
+	if rates["CHF"].Last == "1" {
+		t.Fatal("Expected the expired override to be ignored")
+	}
+
+	data, err := ioutil.ReadFile(path.Join(outPath, "api_v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gbp := extended["GBP"]
+	if gbp.Source != overrideSource || gbp.Override == nil || gbp.Override.Action != OverrideFixed || gbp.Override.Reason != "feed incident" {
+		t.Fatal("Expected GBP to be flagged as overridden:", gbp)
+	}
+	if extended["CHF"].Override != nil {
+		t.Fatal("Unexpected override for CHF:", extended["CHF"].Override)
+	}
+
+	status, err := loadPublishedStatus(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Overridden) != 4 || status.Overridden["ETH"] != "freeze: provider outage" {
+		t.Fatal("Incorrect overridden symbols:", status.Overridden)
+	}
+	if !strings.Contains(status.Overridden["FOO"], "no published rate") {
+		t.Fatal("Expected FOO to have no rate to freeze:", status.Overridden["FOO"])
+	}
+}
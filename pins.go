@@ -0,0 +1,218 @@
+package ticker
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gocraft/health"
+)
+
+const cmcIDMapPath = "/v1/cryptocurrency/map"
+
+// cmcIDMapLimit is the largest page size allowed by the CMC ID map endpoint
+var cmcIDMapLimit = 5000
+
+type cmcIDMapResponse struct {
+	Data []struct {
+		ID     int64  `json:"id"`
+		Symbol string `json:"symbol"`
+		Name   string `json:"name"`
+		Rank   int64  `json:"rank"`
+	} `json:"data"`
+}
+
+// symbolIDTracker records the CMC IDs seen for each symbol during a run
+type symbolIDTracker map[string][]int64
+
+func (t symbolIDTracker) add(symbol string, id int64) {
+	for _, seenID := range t[symbol] {
+		if seenID == id {
+			return
+		}
+	}
+	t[symbol] = append(t[symbol], id)
+}
+
+// warnUnpinnedDuplicates emits an event for each symbol that was seen with
+// more than one ID but isn't pinned to one of them
+func (t symbolIDTracker) warnUnpinnedDuplicates(job *health.Job, source string) {
+	symbols := make([]string, 0, len(t))
+	for symbol := range t {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		ids := t[symbol]
+		if len(ids) < 2 {
+			continue
+		}
+		if _, ok := pinnedIDForSymbol(symbol); ok {
+			continue
+		}
+
+		idStrs := make([]string, len(ids))
+		for i, id := range ids {
+			idStrs[i] = fmt.Sprintf("%d", id)
+		}
+		job.EventKv("warn.unpinned_duplicate", health.Kvs{
+			"source": source,
+			"symbol": symbol,
+			"ids":    strings.Join(idStrs, ","),
+		})
+	}
+}
+
+// SymbolCollisionCoin is one of the coins sharing a symbol
+type SymbolCollisionCoin struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Rank int64  `json:"rank"`
+}
+
+// SymbolCollision is a symbol used by more than one coin on CMC
+type SymbolCollision struct {
+	Symbol string `json:"symbol"`
+
+	// Coins are the coins using the symbol, ordered by rank
+	Coins []SymbolCollisionCoin `json:"coins"`
+
+	// SuggestedID is the ID of the best ranked coin
+	SuggestedID int64 `json:"suggestedId"`
+
+	// PinnedID is the ID the symbol is currently pinned to, or 0
+	PinnedID int64 `json:"pinnedId"`
+}
+
+// PinChange is a difference between the pinned symbols of the current policy
+// and the suggested pins
+type PinChange struct {
+	Symbol string `json:"symbol"`
+
+	// From is the currently pinned ID, or 0 if the symbol isn't pinned
+	From int64 `json:"from"`
+
+	// To is the suggested ID, or 0 if the symbol no longer needs a pin
+	To int64 `json:"to"`
+}
+
+// DiscoverSymbolCollisions pages through the CMC ID map and returns every
+// symbol used by more than one coin, ordered by symbol. Rate limited or
+// rejected keys are rotated like they are when fetching.
+func DiscoverSymbolCollisions(stream *health.Stream, baseURL string, apiKeys ...string) ([]SymbolCollision, error) {
+	job := stream.NewJob("pins_check")
+	if len(apiKeys) == 0 {
+		err := errInvalidConfig("no CMC API keys to check pins with")
+		job.EventErr("fetch_id_map", err)
+		job.Complete(health.Error)
+		return nil, err
+	}
+
+	coinsBySymbol := map[string][]SymbolCollisionCoin{}
+	for i := 0; i < 100; i++ {
+		resp, err := fetchCMCIDMap(job, baseURL, apiKeys, cmcQueryFirstID+(i*cmcIDMapLimit), cmcIDMapLimit)
+		if err != nil {
+			job.EventErr("fetch_id_map", err)
+			job.Complete(health.Error)
+			return nil, err
+		}
+
+		for _, entry := range resp.Data {
+			symbol := CanonicalizeSymbol(entry.Symbol)
+			coinsBySymbol[symbol] = append(coinsBySymbol[symbol], SymbolCollisionCoin{
+				ID:   entry.ID,
+				Name: entry.Name,
+				Rank: entry.Rank,
+			})
+		}
+
+		// We aren't getting any more data; stop
+		if len(resp.Data) < cmcIDMapLimit {
+			break
+		}
+	}
+
+	job.Complete(health.Success)
+	return findSymbolCollisions(coinsBySymbol), nil
+}
+
+func findSymbolCollisions(coinsBySymbol map[string][]SymbolCollisionCoin) []SymbolCollision {
+	collisions := []SymbolCollision{}
+	for symbol, coins := range coinsBySymbol {
+		if len(coins) < 2 {
+			continue
+		}
+
+		// Unranked coins have a rank of 0 and sort last
+		sort.Slice(coins, func(i, j int) bool {
+			if (coins[i].Rank == 0) != (coins[j].Rank == 0) {
+				return coins[j].Rank == 0
+			}
+			if coins[i].Rank != coins[j].Rank {
+				return coins[i].Rank < coins[j].Rank
+			}
+			return coins[i].ID < coins[j].ID
+		})
+
+		pinnedID, _ := pinnedIDForSymbol(symbol)
+		collisions = append(collisions, SymbolCollision{
+			Symbol:      symbol,
+			Coins:       coins,
+			SuggestedID: coins[0].ID,
+			PinnedID:    pinnedID,
+		})
+	}
+
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i].Symbol < collisions[j].Symbol
+	})
+	return collisions
+}
+
+// DiffPins compares the suggested pins for the given collisions against the
+// pinned symbols of the current policy. Pinned symbols that no longer collide
+// are reported with a To of 0.
+func DiffPins(collisions []SymbolCollision) []PinChange {
+	changes := []PinChange{}
+	colliding := map[string]bool{}
+	for _, collision := range collisions {
+		colliding[collision.Symbol] = true
+		if collision.PinnedID != collision.SuggestedID {
+			changes = append(changes, PinChange{
+				Symbol: collision.Symbol,
+				From:   collision.PinnedID,
+				To:     collision.SuggestedID,
+			})
+		}
+	}
+
+	for symbol, id := range CurrentSymbolPolicy().Pinned {
+		if !colliding[symbol] {
+			changes = append(changes, PinChange{Symbol: symbol, From: id})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Symbol < changes[j].Symbol
+	})
+	return changes
+}
+
+func fetchCMCIDMap(job *health.Job, baseURL string, apiKeys []string, start int, limit int) (*cmcIDMapResponse, error) {
+	q := url.Values{}
+	q.Add("start", fmt.Sprintf("%v", start))
+	q.Add("limit", fmt.Sprintf("%v", limit))
+	q.Add("sort", "cmc_rank")
+
+	payload := &cmcIDMapResponse{}
+	err := rotateKeys(job, "cmc", len(apiKeys), func(key int) (int, error) {
+		return getCMCResource(httpClient, baseURL+cmcIDMapPath, apiKeys[key], q, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
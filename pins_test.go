@@ -0,0 +1,116 @@
+package ticker
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/gocraft/health"
+	"github.com/jarcoal/httpmock"
+)
+
+func TestDiscoverSymbolCollisions(t *testing.T) {
+	err := SetSymbolPolicy(SymbolPolicy{Pinned: map[string]int64{"ACC": 2225, "BTC": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	idMap := httpmock.NewStringResponder(200, `{
+		"data": [
+			{"id": 2224, "symbol": "ACC", "name": "AdCoin", "rank": 900},
+			{"id": 2225, "symbol": "ACC", "name": "Accelerator Network", "rank": 1200},
+			{"id": 2226, "symbol": "ACC", "name": "ACChain", "rank": 0},
+			{"id": 7, "symbol": "FOO", "name": "Foo", "rank": 0},
+			{"id": 8, "symbol": "FOO", "name": "Foo Classic", "rank": 50},
+			{"id": 1, "symbol": "BTC", "name": "Bitcoin", "rank": 1}
+		]
+	}`)
+	httpmock.RegisterResponder("GET", testCMCBaseURL+cmcIDMapPath, func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("X-CMC_PRO_API_KEY") != "second-key" {
+			return httpmock.NewStringResponse(http.StatusTooManyRequests, `{}`), nil
+		}
+		return idMap(req)
+	})
+
+	// The rate limited key is rotated out
+	collisions, err := DiscoverSymbolCollisions(health.NewStream(), testCMCBaseURL, "first-key", "second-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(collisions) != 2 || collisions[0].Symbol != "ACC" || collisions[1].Symbol != "FOO" {
+		t.Fatal("Incorrect collisions:", collisions)
+	}
+	if collisions[0].SuggestedID != 2224 || collisions[0].PinnedID != 2225 || collisions[0].Coins[2].ID != 2226 {
+		t.Fatal("Incorrect ACC collision:", collisions[0])
+	}
+	if collisions[1].SuggestedID != 8 || collisions[1].PinnedID != 0 {
+		t.Fatal("Incorrect FOO collision:", collisions[1])
+	}
+
+	expectedChanges := []PinChange{
+		{Symbol: "ACC", From: 2225, To: 2224},
+		{Symbol: "BTC", From: 1},
+		{Symbol: "FOO", To: 8},
+	}
+	if changes := DiffPins(collisions); !reflect.DeepEqual(changes, expectedChanges) {
+		t.Fatal("Incorrect pin changes:", changes)
+	}
+
+	if _, err := DiscoverSymbolCollisions(health.NewStream(), testCMCBaseURL); err == nil {
+		t.Fatal("Expected an error without API keys")
+	}
+}
+
+func TestWarnUnpinnedDuplicates(t *testing.T) {
+	sink := &testEventSink{}
+	stream := health.NewStream()
+	stream.AddSink(sink)
+
+	seenIDs := symbolIDTracker{}
+	seenIDs.add("ACC", 2224)
+	seenIDs.add("ACC", 2225)
+	seenIDs.add("FOO", 7)
+	seenIDs.add("FOO", 8)
+	seenIDs.add("FOO", 7)
+	seenIDs.add("BAR", 9)
+	seenIDs.warnUnpinnedDuplicates(stream.NewJob("fetch"), "cmc")
+
+	expected := []string{"warn.unpinned_duplicate FOO 7,8"}
+	if !reflect.DeepEqual(sink.events, expected) {
+		t.Fatal("Incorrect warnings:", sink.events)
+	}
+}
+
+// testEventSink records events emitted to it
+type testEventSink struct {
+	sync.Mutex
+	events []string
+}
+
+func (s *testEventSink) EmitEvent(job string, event string, kvs map[string]string) {
+	s.Lock()
+	defer s.Unlock()
+	if symbol, ok := kvs["symbol"]; ok {
+		event += " " + symbol
+	}
+	if ids, ok := kvs["ids"]; ok {
+		event += " " + ids
+	}
+	s.events = append(s.events, event)
+}
+
+func (s *testEventSink) EmitEventErr(job string, event string, err error, kvs map[string]string) {
+	s.EmitEvent(job, event+".err", kvs)
+}
+
+func (s *testEventSink) EmitTiming(job string, event string, nanos int64, kvs map[string]string) {}
+
+func (s *testEventSink) EmitGauge(job string, event string, value float64, kvs map[string]string) {}
+
+func (s *testEventSink) EmitComplete(job string, status health.CompletionStatus, nanos int64, kvs map[string]string) {
+}
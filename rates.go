@@ -12,6 +12,30 @@ type exchangeRate struct {
 	Bid  json.Number `json:"bid"`
 	Last json.Number `json:"last"`
 	Type string      `json:"type"`
+
+	// Name and CMCID describe the currency and aren't published with the rate
+	Name  string `json:"-"`
+	CMCID int64  `json:"-"`
+
+	// Source is the provider the rate came from and Path how it was derived,
+	// if it wasn't returned by the provider directly. Derived is set for rates
+	// computed from other rates. They are only published in the extended
+	// document.
+	Source  string   `json:"-"`
+	Path    []string `json:"-"`
+	Derived bool     `json:"-"`
+
+	// Raw holds the fetched prices of a rate smoothed with the Smoothing
+	// method. They are only published in the extended document.
+	Raw       *rawPrices `json:"-"`
+	Smoothing string     `json:"-"`
+
+	// Market describes the coin's market, when a provider reports it
+	Market *marketData `json:"-"`
+
+	// Override is set for rates fixed or frozen by an override. It is only
+	// published in the extended document.
+	Override *rateOverride `json:"-"`
 }
 
 // exchangeRates represents a map of symbols to rate data for that symbol
@@ -33,6 +57,16 @@ func mergeRates(allRates []exchangeRates) exchangeRates {
 
 	for _, rates := range allRates[1:] {
 		for k, v := range rates {
+			// Keep the description and market of a currency when a later
+			// source, like an exchange, doesn't have them
+			if existing, ok := base[k]; ok {
+				if v.Name == "" {
+					v.Name, v.CMCID = existing.Name, existing.CMCID
+				}
+				if v.Market == nil {
+					v.Market = existing.Market
+				}
+			}
 			base[k] = v
 		}
 	}
@@ -53,3 +87,57 @@ func invertAndFormatPrice(price json.Number) (json.Number, error) {
 	}
 	return json.Number(strconv.FormatFloat(1.0/priceAsFloat, 'f', -1, 32)), nil
 }
+
+// pairRate converts the ticker of a BASE/QUOTE market into the rate of a symbol
+// against BTC. Markets quoted in BTC are inverted, which swaps their ask and
+// bid. Markets that don't involve BTC are returned as cross quotes keyed by
+// BASE/QUOTE. ok is false for banned symbols and tickers without prices.
+func pairRate(base, quote string, ask, bid, last json.Number) (string, exchangeRate, bool, error) {
+	if ask == "" || bid == "" || last == "" {
+		return "", exchangeRate{}, false, nil
+	}
+
+	switch {
+	case base == "BTC" && quote != "BTC":
+		rate := exchangeRate{Ask: ask, Bid: bid, Last: last, Type: exchangeRateTypeFiat.String()}
+		if _, ok := iso4217Currencies[quote]; !ok {
+			if isBannedCryptoSymbol(quote) {
+				return "", exchangeRate{}, false, nil
+			}
+			rate.Type = exchangeRateTypeCrypto.String()
+		}
+		return quote, rate, true, nil
+
+	case quote == "BTC" && base != "BTC":
+		if isBannedCryptoSymbol(base) {
+			return "", exchangeRate{}, false, nil
+		}
+		invertedAsk, err := invertAndFormatPrice(bid)
+		if err != nil {
+			return "", exchangeRate{}, false, err
+		}
+		invertedBid, err := invertAndFormatPrice(ask)
+		if err != nil {
+			return "", exchangeRate{}, false, err
+		}
+		invertedLast, err := invertAndFormatPrice(last)
+		if err != nil {
+			return "", exchangeRate{}, false, err
+		}
+		return base, exchangeRate{
+			Ask:  invertedAsk,
+			Bid:  invertedBid,
+			Last: invertedLast,
+			Type: exchangeRateTypeCrypto.String(),
+		}, true, nil
+
+	case base != quote && base != "BTC":
+		for _, symbol := range []string{base, quote} {
+			if _, fiat := iso4217Currencies[symbol]; !fiat && isBannedCryptoSymbol(symbol) {
+				return "", exchangeRate{}, false, nil
+			}
+		}
+		return base + "/" + quote, exchangeRate{Ask: ask, Bid: bid, Last: last, Type: exchangeRateTypeCross.String()}, true, nil
+	}
+	return "", exchangeRate{}, false, nil
+}
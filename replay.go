@@ -0,0 +1,187 @@
+package ticker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// scrubbedHeaders are the request headers that carry credentials. They are
+// replaced in recorded fixtures.
+var scrubbedHeaders = []string{"X-Signature", "X-CMC_PRO_API_KEY"}
+
+// scrubbedQueryParams are the query parameters that carry credentials
+var scrubbedQueryParams = []string{"CMC_PRO_API_KEY"}
+
+// recordedResponseHeaders are the response headers kept in recorded fixtures
+var recordedResponseHeaders = []string{"Content-Type"}
+
+const scrubbedValue = "SCRUBBED"
+
+var fixtureNameSanitizer = regexp.MustCompile("[^a-zA-Z0-9.=-]+")
+
+// fixture is a recorded HTTP exchange
+type fixture struct {
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"request_header"`
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+
+	// Body holds JSON response bodies and BodyText any other response body
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"body_text,omitempty"`
+}
+
+// RecordingTransport is an http.RoundTripper that saves every exchange made
+// through the base transport as a fixture file in Dir. Credentials are
+// scrubbed from the saved requests.
+type RecordingTransport struct {
+	Dir  string
+	Base http.RoundTripper
+
+	mu sync.Mutex
+}
+
+// RoundTrip performs the request with the base transport and records it
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	f := fixture{
+		Method:        req.Method,
+		URL:           scrubURL(req.URL),
+		RequestHeader: http.Header{},
+		Status:        resp.StatusCode,
+		Header:        http.Header{},
+	}
+	for key, values := range req.Header {
+		f.RequestHeader[key] = values
+	}
+	for _, key := range scrubbedHeaders {
+		if f.RequestHeader.Get(key) != "" {
+			f.RequestHeader.Set(key, scrubbedValue)
+		}
+	}
+	for _, key := range recordedResponseHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			f.Header.Set(key, value)
+		}
+	}
+	if json.Valid(body) {
+		f.Body = json.RawMessage(body)
+	} else {
+		f.BodyText = string(body)
+	}
+
+	data := &bytes.Buffer{}
+	encoder := json.NewEncoder(data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err = os.MkdirAll(t.Dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(t.Dir, fixtureName(req.Method, f.URL)), data.Bytes(), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ReplayTransport is an http.RoundTripper that answers requests with the
+// fixtures saved in Dir by a RecordingTransport. Requests without a fixture
+// fail.
+type ReplayTransport struct {
+	Dir string
+}
+
+// RoundTrip returns the recorded response for the request
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	location := scrubURL(req.URL)
+	data, err := ioutil.ReadFile(filepath.Join(t.Dir, fixtureName(req.Method, location)))
+	if os.IsNotExist(err) {
+		return nil, errNoFixture(req.Method + " " + location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := fixture{}
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte(f.BodyText)
+	if len(f.Body) > 0 {
+		body = f.Body
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(f.Status),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// scrubURL returns the URL with credentials in the query replaced
+func scrubURL(u *url.URL) string {
+	scrubbed := *u
+	query := scrubbed.Query()
+	for _, key := range scrubbedQueryParams {
+		if query.Get(key) != "" {
+			query.Set(key, scrubbedValue)
+		}
+	}
+	scrubbed.RawQuery = query.Encode()
+	return scrubbed.String()
+}
+
+// fixtureName returns the file name of the fixture for a request
+func fixtureName(method string, location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fixtureNameSanitizer.ReplaceAllString(method+"_"+location, "_") + ".json"
+	}
+	name := method + "_" + u.Host + u.Path
+	if u.RawQuery != "" {
+		name += "_" + u.RawQuery
+	}
+	return fixtureNameSanitizer.ReplaceAllString(name, "_") + ".json"
+}
+
+type errNoFixture string
+
+func (e errNoFixture) Error() string {
+	return "No recorded fixture for request: " + string(e)
+}
@@ -0,0 +1,113 @@
+package ticker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const s3URLPrefix = "s3://"
+
+// readResource reads the contents of a local path or an s3://bucket/key URL.
+// S3 resources are read from the configured region.
+func readResource(conf Config, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, s3URLPrefix) {
+		return ioutil.ReadFile(location)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(location, s3URLPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errInvalidResourceLocation(location)
+	}
+
+	s3CFG := aws.NewConfig().WithRegion(conf.AWSS3Region).WithCredentials(credentials.NewEnvCredentials())
+	resp, err := s3.New(session.New(), s3CFG).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(parts[0]),
+		Key:    aws.String(parts[1]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// writeResource writes data to a local path, creating its directory, or to an
+// s3://bucket/key URL in the configured region
+func writeResource(conf Config, location string, data []byte) error {
+	if !strings.HasPrefix(location, s3URLPrefix) {
+		err := os.MkdirAll(path.Dir(location), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(location, data, 0644)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(location, s3URLPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return errInvalidResourceLocation(location)
+	}
+
+	s3CFG := aws.NewConfig().WithRegion(conf.AWSS3Region).WithCredentials(credentials.NewEnvCredentials())
+	_, err := s3.New(session.New(), s3CFG).PutObject(&s3.PutObjectInput{
+		Bucket:        aws.String(parts[0]),
+		Key:           aws.String(parts[1]),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String("application/json"),
+	})
+	return err
+}
+
+// publishedLocation returns where the artifact with the given name is
+// published, preferring S3 over the local output path
+func publishedLocation(conf Config, name string) string {
+	if conf.AWSS3Bucket != "" {
+		return s3URLPrefix + conf.AWSS3Bucket + "/" + name
+	}
+	return path.Join(conf.OutPath, name)
+}
+
+// loadPublishedRates reads the last published api document. It returns nil if
+// nothing has been published yet.
+func loadPublishedRates(conf Config) (exchangeRates, error) {
+	data, err := readResource(conf, publishedLocation(conf, "api"))
+	if isResourceNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rates := exchangeRates{}
+	err = json.Unmarshal(data, &rates)
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// isResourceNotFound checks if the error from readResource means the resource
+// doesn't exist
+func isResourceNotFound(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == s3.ErrCodeNoSuchKey
+	}
+	return os.IsNotExist(err)
+}
+
+type errInvalidResourceLocation string
+
+func (e errInvalidResourceLocation) Error() string {
+	return "Invalid resource location: " + string(e)
+}
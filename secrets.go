@@ -0,0 +1,281 @@
+package ticker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gocraft/health"
+)
+
+// Secret backends
+const (
+	// SecretsBackendEnv reads each secret from an environment variable
+	SecretsBackendEnv = "env"
+
+	// SecretsBackendFile reads secrets from a JSON file of names to values
+	SecretsBackendFile = "file"
+
+	// SecretsBackendDir reads each secret from a file of the same name in a
+	// directory, like Docker and Kubernetes secrets
+	SecretsBackendDir = "dir"
+)
+
+// secretReferencePrefix marks a config value as the name of a secret, e.g.
+// secret:cmc_api_key
+const secretReferencePrefix = "secret:"
+
+// SecretsConfig selects where the secrets referenced by the config are read
+// from
+type SecretsConfig struct {
+	Backend string `json:"backend"`
+
+	// Path is the JSON file for the file backend and the directory for the dir
+	// backend, e.g. /run/secrets
+	Path string `json:"path,omitempty"`
+
+	// Prefix is prepended to secret names for the env backend, e.g. SECRET_
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// DefaultSecretsConfig returns the secrets config used when none is configured
+func DefaultSecretsConfig() SecretsConfig {
+	return SecretsConfig{Backend: SecretsBackendEnv}
+}
+
+// validate checks that the secrets config is well formed
+func (c SecretsConfig) validate() error {
+	switch c.Backend {
+	case SecretsBackendEnv:
+	case SecretsBackendFile, SecretsBackendDir:
+		if c.Path == "" {
+			return fmt.Errorf("%s secrets backend requires path", c.Backend)
+		}
+	default:
+		return fmt.Errorf("unknown secrets backend %q", c.Backend)
+	}
+	return nil
+}
+
+// SecretStore looks up secrets by name
+type SecretStore interface {
+	Secret(name string) (string, error)
+}
+
+// NewSecretStore creates the SecretStore for the configured backend
+func NewSecretStore(conf SecretsConfig) (SecretStore, error) {
+	switch conf.Backend {
+	case SecretsBackendEnv:
+		return envSecretStore{prefix: conf.Prefix}, nil
+	case SecretsBackendFile:
+		data, err := ioutil.ReadFile(conf.Path)
+		if err != nil {
+			return nil, err
+		}
+		secrets := fileSecretStore{}
+		err = json.Unmarshal(data, &secrets)
+		if err != nil {
+			return nil, fmt.Errorf("parsing secrets file %s: %s", conf.Path, err)
+		}
+		return secrets, nil
+	case SecretsBackendDir:
+		return dirSecretStore{path: conf.Path}, nil
+	}
+	return nil, fmt.Errorf("unknown secrets backend %q", conf.Backend)
+}
+
+// envSecretStore reads secrets from environment variables named by the prefix
+// and the upper cased secret name
+type envSecretStore struct {
+	prefix string
+}
+
+func (s envSecretStore) Secret(name string) (string, error) {
+	value := os.Getenv(s.prefix + strings.ToUpper(name))
+	if value == "" {
+		return "", errSecretNotFound(name)
+	}
+	return value, nil
+}
+
+// fileSecretStore holds the secrets read from a JSON file
+type fileSecretStore map[string]string
+
+func (s fileSecretStore) Secret(name string) (string, error) {
+	value, ok := s[name]
+	if !ok || value == "" {
+		return "", errSecretNotFound(name)
+	}
+	return value, nil
+}
+
+// dirSecretStore reads each secret from a file in a directory. Trailing
+// newlines are trimmed.
+type dirSecretStore struct {
+	path string
+}
+
+func (s dirSecretStore) Secret(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errSecretNotFound(name)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.path, name))
+	if os.IsNotExist(err) {
+		return "", errSecretNotFound(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", errSecretNotFound(name)
+	}
+	return value, nil
+}
+
+// ResolveSecrets replaces config values of the form secret:NAME with the named
+// secret from the configured backend. Resolved values are remembered so they
+// are redacted from the config dump and health events.
+func (c *Config) ResolveSecrets() error {
+	if !c.hasSecretReferences() {
+		return nil
+	}
+
+	store, err := NewSecretStore(c.Secrets)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range c.secretFields() {
+		if !strings.HasPrefix(*field, secretReferencePrefix) {
+			continue
+		}
+		value, err := store.Secret(strings.TrimPrefix(*field, secretReferencePrefix))
+		if err != nil {
+			return err
+		}
+		*field = value
+		c.secretValues = append(c.secretValues, value)
+	}
+	return nil
+}
+
+func (c *Config) hasSecretReferences() bool {
+	for _, field := range c.secretFields() {
+		if strings.HasPrefix(*field, secretReferencePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretFields returns the config values that may reference secrets: every
+// setting with a config var, the extra API keys and the sink API keys
+func (c *Config) secretFields() []*string {
+	fields := []*string{}
+	for _, v := range configVars {
+		fields = append(fields, v.field(c))
+	}
+	for i := range c.BTCAVGKeys {
+		fields = append(fields, &c.BTCAVGKeys[i].Pubkey, &c.BTCAVGKeys[i].Privkey)
+	}
+	for i := range c.CMCAPIKeys {
+		fields = append(fields, &c.CMCAPIKeys[i])
+	}
+	for i := range c.Health.Sinks {
+		fields = append(fields, &c.Health.Sinks[i].APIKey)
+	}
+	return fields
+}
+
+// isSecretValue checks if a value was resolved from a secret
+func (c Config) isSecretValue(value string) bool {
+	return value != "" && containsString(c.secretValues, value)
+}
+
+// redactedValues returns the values redacted from health events: every
+// credential in the config, whether it was given in the file, the environment,
+// a flag or a secret, and every other value resolved from a secret
+func (c Config) redactedValues() []string {
+	values := []string{}
+	add := func(value string) {
+		if value != "" && value != redactedConfigValue && !containsString(values, value) {
+			values = append(values, value)
+		}
+	}
+	for _, v := range configVars {
+		if v.secret {
+			add(*v.field(&c))
+		}
+	}
+	for _, key := range c.BTCAVGKeys {
+		add(key.Privkey)
+	}
+	for _, key := range c.CMCAPIKeys {
+		add(key)
+	}
+	for _, sink := range c.Health.Sinks {
+		add(sink.APIKey)
+	}
+	for _, value := range c.secretValues {
+		add(value)
+	}
+	return values
+}
+
+// redactingSink replaces secret values in the events sent to the wrapped sink
+type redactingSink struct {
+	health.Sink
+	replacer *strings.Replacer
+}
+
+func newRedactingSink(sink health.Sink, secrets []string) *redactingSink {
+	pairs := make([]string, 0, len(secrets)*2)
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, redactedConfigValue)
+	}
+	return &redactingSink{Sink: sink, replacer: strings.NewReplacer(pairs...)}
+}
+
+func (s *redactingSink) redact(kvs map[string]string) map[string]string {
+	redacted := make(map[string]string, len(kvs))
+	for key, value := range kvs {
+		redacted[key] = s.replacer.Replace(value)
+	}
+	return redacted
+}
+
+func (s *redactingSink) EmitEvent(job string, event string, kvs map[string]string) {
+	s.Sink.EmitEvent(job, event, s.redact(kvs))
+}
+
+func (s *redactingSink) EmitEventErr(job string, event string, err error, kvs map[string]string) {
+	if err != nil {
+		if message := s.replacer.Replace(err.Error()); message != err.Error() {
+			err = errors.New(message)
+		}
+	}
+	s.Sink.EmitEventErr(job, event, err, s.redact(kvs))
+}
+
+func (s *redactingSink) EmitTiming(job string, event string, nanos int64, kvs map[string]string) {
+	s.Sink.EmitTiming(job, event, nanos, s.redact(kvs))
+}
+
+func (s *redactingSink) EmitGauge(job string, event string, value float64, kvs map[string]string) {
+	s.Sink.EmitGauge(job, event, value, s.redact(kvs))
+}
+
+func (s *redactingSink) EmitComplete(job string, status health.CompletionStatus, nanos int64, kvs map[string]string) {
+	s.Sink.EmitComplete(job, status, nanos, s.redact(kvs))
+}
+
+type errSecretNotFound string
+
+func (e errSecretNotFound) Error() string {
+	return "Secret not found: " + string(e)
+}
@@ -0,0 +1,115 @@
+package ticker
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecretStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ticker-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "cmc_api_key"), []byte("dir-key\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "secrets.json")
+	err = ioutil.WriteFile(file, []byte(`{"cmc_api_key": "file-key"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_SECRET_CMC_API_KEY", "env-key")
+	defer os.Unsetenv("TEST_SECRET_CMC_API_KEY")
+
+	for _, test := range []struct {
+		conf     SecretsConfig
+		expected string
+	}{
+		{SecretsConfig{Backend: SecretsBackendEnv, Prefix: "TEST_SECRET_"}, "env-key"},
+		{SecretsConfig{Backend: SecretsBackendFile, Path: file}, "file-key"},
+		{SecretsConfig{Backend: SecretsBackendDir, Path: dir}, "dir-key"},
+	} {
+		store, err := NewSecretStore(test.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := store.Secret("cmc_api_key")
+		if err != nil || value != test.expected {
+			t.Fatalf("Incorrect %s secret: %q, %v", test.conf.Backend, value, err)
+		}
+		if _, err := store.Secret("missing"); err != errSecretNotFound("missing") {
+			t.Fatalf("Expected a missing %s secret, got %v", test.conf.Backend, err)
+		}
+	}
+
+	store, _ := NewSecretStore(SecretsConfig{Backend: SecretsBackendDir, Path: dir})
+	if _, err := store.Secret("../" + filepath.Base(dir) + "/cmc_api_key"); err == nil {
+		t.Fatal("Expected secret names outside the directory to be rejected")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	os.Setenv("CMC_KEY", "resolved-cmc-key")
+	defer os.Unsetenv("CMC_KEY")
+	os.Setenv("BTCAVG_PUBKEY", "resolved-pubkey")
+	defer os.Unsetenv("BTCAVG_PUBKEY")
+
+	conf := DefaultConfig()
+	conf.CMCAPIKey = "secret:cmc_key"
+	conf.BTCAVGPubkey = "secret:btcavg_pubkey"
+	conf.BTCAVGPrivkey = "plain-privkey"
+	if err := conf.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.CMCAPIKey != "resolved-cmc-key" || conf.BTCAVGPubkey != "resolved-pubkey" {
+		t.Fatal("Secrets were not resolved:", conf.CMCAPIKey, conf.BTCAVGPubkey)
+	}
+
+	redacted := conf.Redacted()
+	if redacted.BTCAVGPubkey != redactedConfigValue || redacted.CMCAPIKey != redactedConfigValue {
+		t.Fatal("Secrets were not redacted:", redacted)
+	}
+
+	// Credentials given directly are redacted along with the resolved secrets
+	sink := &recordingSink{}
+	redacting := newRedactingSink(sink, conf.redactedValues())
+	redacting.EmitEvent("fetch", "event", map[string]string{"key": "uses resolved-pubkey", "region": "us-east-1"})
+	redacting.EmitEventErr("fetch", "event", errors.New("rejected resolved-cmc-key"), nil)
+	redacting.EmitEventErr("fetch", "event", errors.New("https://example.com/?key=plain-privkey failed"), nil)
+	expected := []string{"uses [redacted]", "us-east-1", "rejected [redacted]", "https://example.com/?key=[redacted] failed"}
+	if !reflect.DeepEqual(sink.values, expected) {
+		t.Fatal("Incorrect redaction:", sink.values)
+	}
+
+	conf = DefaultConfig()
+	conf.CMCAPIKey = "secret:missing_key"
+	if err := conf.ResolveSecrets(); err == nil || !strings.Contains(err.Error(), "missing_key") {
+		t.Fatal("Expected a missing secret error, got", err)
+	}
+}
+
+// recordingSink records the key and region kvs and the errors emitted to it
+type recordingSink struct {
+	testEventSink
+	values []string
+}
+
+func (s *recordingSink) EmitEvent(job string, event string, kvs map[string]string) {
+	for _, key := range []string{"key", "region"} {
+		if value, ok := kvs[key]; ok {
+			s.values = append(s.values, value)
+		}
+	}
+}
+
+func (s *recordingSink) EmitEventErr(job string, event string, err error, kvs map[string]string) {
+	s.values = append(s.values, err.Error())
+}
@@ -0,0 +1,204 @@
+package ticker
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/gocraft/health"
+	"github.com/gocraft/health/sinks/bugsnag"
+)
+
+// Health sink types
+const (
+	// SinkTypeWriter writes plain text lines to stdout or stderr
+	SinkTypeWriter = "writer"
+
+	// SinkTypeJSON writes JSON lines to stdout or stderr
+	SinkTypeJSON = "json"
+
+	// SinkTypeStatsD sends metrics to a StatsD server
+	SinkTypeStatsD = "statsd"
+
+	// SinkTypeBugsnag reports errors to Bugsnag
+	SinkTypeBugsnag = "bugsnag"
+
+	// SinkTypeJSONPolling aggregates metrics and serves them as JSON over HTTP
+	SinkTypeJSONPolling = "json_polling"
+)
+
+// HealthConfig selects where health events are sent
+type HealthConfig struct {
+	Sinks []HealthSinkConfig `json:"sinks"`
+
+	// KeyValues are added to every event, e.g. {"env": "production"}
+	KeyValues map[string]string `json:"key_values,omitempty"`
+}
+
+// HealthSinkConfig configures a single health sink
+type HealthSinkConfig struct {
+	Type string `json:"type"`
+
+	// Output is stdout or stderr for writer and json sinks. Empty means stdout.
+	Output string `json:"output,omitempty"`
+
+	// Addr is the server address for statsd sinks and the address to listen on
+	// for json_polling sinks
+	Addr string `json:"addr,omitempty"`
+
+	// Prefix is prepended to statsd metric names
+	Prefix string `json:"prefix,omitempty"`
+
+	// APIKey is the Bugsnag API key for bugsnag sinks
+	APIKey string `json:"api_key,omitempty"`
+
+	// SampleRate is the fraction of events, timings and gauges that are sent to
+	// the sink. Errors and job completions are always sent. 0 means 1.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// DefaultHealthConfig returns the health config used when none is configured
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		Sinks: []HealthSinkConfig{{Type: SinkTypeWriter, Output: "stdout"}},
+	}
+}
+
+// validate checks that the sink is well formed
+func (c HealthSinkConfig) validate() error {
+	switch c.Type {
+	case SinkTypeWriter, SinkTypeJSON:
+		if c.Output != "" && c.Output != "stdout" && c.Output != "stderr" {
+			return fmt.Errorf("%s sink output must be stdout or stderr, got %q", c.Type, c.Output)
+		}
+	case SinkTypeStatsD, SinkTypeJSONPolling:
+		if c.Addr == "" {
+			return fmt.Errorf("%s sink requires addr", c.Type)
+		}
+	case SinkTypeBugsnag:
+		if c.APIKey == "" {
+			return fmt.Errorf("bugsnag sink requires api_key")
+		}
+	default:
+		return fmt.Errorf("unknown health sink type %q", c.Type)
+	}
+
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("%s sink sample_rate must be between 0 and 1, got %v", c.Type, c.SampleRate)
+	}
+
+	return nil
+}
+
+// NewHealthStream builds a health stream with the sinks and key/values from the
+// Config. The deprecated bugsnag_api_key setting adds a Bugsnag sink.
+// Credentials and values resolved from secrets are redacted from every event.
+func NewHealthStream(conf Config) (*health.Stream, error) {
+	stream := health.NewStream()
+	for key, value := range conf.Health.KeyValues {
+		stream.KeyValue(key, value)
+	}
+
+	sinkConfigs := conf.Health.Sinks
+	if conf.BugsnagAPIKey != "" {
+		sinkConfigs = append(sinkConfigs, HealthSinkConfig{Type: SinkTypeBugsnag, APIKey: conf.BugsnagAPIKey})
+	}
+
+	redactedValues := conf.redactedValues()
+	for _, sinkConf := range sinkConfigs {
+		sink, err := newHealthSink(sinkConf)
+		if err != nil {
+			return nil, err
+		}
+		if sinkConf.SampleRate > 0 && sinkConf.SampleRate < 1 {
+			sink = &samplingSink{Sink: sink, rate: sinkConf.SampleRate}
+		}
+		if len(redactedValues) > 0 {
+			sink = newRedactingSink(sink, redactedValues)
+		}
+		stream.AddSink(sink)
+	}
+
+	return stream, nil
+}
+
+func newHealthSink(conf HealthSinkConfig) (health.Sink, error) {
+	switch conf.Type {
+	case SinkTypeWriter:
+		return &health.WriterSink{Writer: sinkOutput(conf.Output)}, nil
+	case SinkTypeJSON:
+		return &health.JsonWriterSink{Writer: sinkOutput(conf.Output)}, nil
+	case SinkTypeStatsD:
+		return health.NewStatsDSink(conf.Addr, &health.StatsDSinkOptions{Prefix: conf.Prefix})
+	case SinkTypeBugsnag:
+		return bugsnag.NewSink(&bugsnag.Config{APIKey: conf.APIKey}), nil
+	case SinkTypeJSONPolling:
+		return &jsonPollingSink{JsonPollingSink: health.NewJsonPollingSink(time.Minute, 5*time.Minute), addr: conf.Addr}, nil
+	}
+	return nil, fmt.Errorf("unknown health sink type %q", conf.Type)
+}
+
+func sinkOutput(output string) io.Writer {
+	if output == "stderr" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// jsonPollingSink is a json_polling sink whose server is started separately,
+// so only long running commands listen on its address
+type jsonPollingSink struct {
+	*health.JsonPollingSink
+	addr string
+}
+
+// StartHealthServers starts serving the metrics of the stream's json_polling
+// sinks over HTTP
+func StartHealthServers(stream *health.Stream) {
+	for _, sink := range stream.Sinks {
+		if s, ok := unwrapSink(sink).(*jsonPollingSink); ok {
+			s.StartServer(s.addr)
+		}
+	}
+}
+
+// unwrapSink returns the sink wrapped by sampling and redacting sinks
+func unwrapSink(sink health.Sink) health.Sink {
+	for {
+		switch s := sink.(type) {
+		case *samplingSink:
+			sink = s.Sink
+		case *redactingSink:
+			sink = s.Sink
+		default:
+			return sink
+		}
+	}
+}
+
+// samplingSink forwards a random fraction of events, timings and gauges to
+// the wrapped sink. Errors and job completions are always forwarded.
+type samplingSink struct {
+	health.Sink
+	rate float64
+}
+
+func (s *samplingSink) EmitEvent(job string, event string, kvs map[string]string) {
+	if rand.Float64() < s.rate {
+		s.Sink.EmitEvent(job, event, kvs)
+	}
+}
+
+func (s *samplingSink) EmitTiming(job string, event string, nanos int64, kvs map[string]string) {
+	if rand.Float64() < s.rate {
+		s.Sink.EmitTiming(job, event, nanos, kvs)
+	}
+}
+
+func (s *samplingSink) EmitGauge(job string, event string, value float64, kvs map[string]string) {
+	if rand.Float64() < s.rate {
+		s.Sink.EmitGauge(job, event, value, kvs)
+	}
+}
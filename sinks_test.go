@@ -0,0 +1,117 @@
+package ticker
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+func TestNewHealthStream(t *testing.T) {
+	conf := DefaultConfig()
+	conf.BugsnagAPIKey = "bugsnag-key"
+	conf.Health = HealthConfig{
+		Sinks: []HealthSinkConfig{
+			{Type: SinkTypeJSON, Output: "stdout"},
+			{Type: SinkTypeStatsD, Addr: "127.0.0.1:8125", Prefix: "ticker", SampleRate: 0.5},
+		},
+		KeyValues: map[string]string{"env": "test"},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := NewHealthStream(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Sinks) != 3 || stream.KeyValues["env"] != "test" {
+		t.Fatal("Incorrect stream:", stream.Sinks, stream.KeyValues)
+	}
+	// The Bugsnag key is redacted from every sink
+	for _, sink := range stream.Sinks {
+		if _, ok := sink.(*redactingSink); !ok {
+			t.Fatal("Expected a redacting sink, got:", sink)
+		}
+	}
+	if _, ok := unwrapSink(stream.Sinks[0]).(*health.JsonWriterSink); !ok {
+		t.Fatal("Expected a JSON writer sink, got:", stream.Sinks[0])
+	}
+	sampled, ok := stream.Sinks[1].(*redactingSink).Sink.(*samplingSink)
+	if !ok || sampled.rate != 0.5 {
+		t.Fatal("Expected a sampled StatsD sink, got:", stream.Sinks[1])
+	}
+	sampled.Sink.(*health.StatsDSink).Stop()
+
+	redacted := conf.Redacted()
+	redacted.Health.Sinks = append(redacted.Health.Sinks, HealthSinkConfig{Type: SinkTypeBugsnag, APIKey: "other-key"})
+	if conf.Redacted().BugsnagAPIKey != redactedConfigValue || redacted.Redacted().Health.Sinks[2].APIKey != redactedConfigValue {
+		t.Fatal("Bugsnag keys were not redacted")
+	}
+
+	for _, sink := range []HealthSinkConfig{
+		{Type: "syslog"},
+		{Type: SinkTypeWriter, Output: "file"},
+		{Type: SinkTypeStatsD},
+		{Type: SinkTypeBugsnag},
+		{Type: SinkTypeWriter, SampleRate: 2},
+	} {
+		if sink.validate() == nil {
+			t.Fatal("Expected sink config to be invalid:", sink)
+		}
+	}
+}
+
+func TestSamplingSink(t *testing.T) {
+	recorder := &testEventSink{}
+	stream := health.NewStream()
+	stream.AddSink(&samplingSink{Sink: recorder, rate: 0.000001})
+
+	job := stream.NewJob("fetch")
+	for i := 0; i < 100; i++ {
+		job.Event("sampled")
+	}
+	job.EventErr("fetch_data", errors.New("boom"))
+
+	if len(recorder.events) != 1 || recorder.events[0] != "fetch_data.err" {
+		t.Fatal("Incorrect sampled events:", recorder.events)
+	}
+}
+
+func TestStartHealthServers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	conf := DefaultConfig()
+	conf.Health = HealthConfig{Sinks: []HealthSinkConfig{{Type: SinkTypeJSONPolling, Addr: addr, SampleRate: 0.5}}}
+	stream, err := NewHealthStream(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Building the stream doesn't listen
+	if _, err := http.Get("http://" + addr + "/health"); err == nil {
+		t.Fatal("Expected the json_polling server not to be started")
+	}
+
+	StartHealthServers(stream)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + addr + "/health")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The json_polling server didn't start:", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
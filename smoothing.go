@@ -0,0 +1,262 @@
+package ticker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+// Smoothing methods
+const (
+	// SmoothingEMA is an exponential moving average over runs
+	SmoothingEMA = "ema"
+
+	// SmoothingTWAP is a time weighted average over a window of recent runs
+	SmoothingTWAP = "twap"
+)
+
+// rateHistoryMaxRuns bounds the number of runs kept in the history artifact
+const rateHistoryMaxRuns = 1440
+
+// SmoothingRule smooths the rates of some symbols over recent runs
+type SmoothingRule struct {
+	Method string `json:"method"`
+
+	// Symbols and Types limit the rule to the given symbols and rate types,
+	// e.g. crypto. The rule applies to every symbol when both are empty.
+	Symbols []string `json:"symbols,omitempty"`
+	Types   []string `json:"types,omitempty"`
+
+	// Alpha is the weight of the newest rate for ema rules, between 0 and 1
+	Alpha float64 `json:"alpha,omitempty"`
+
+	// Window is how far back twap rules average over, e.g. 15m
+	Window string `json:"window,omitempty"`
+}
+
+// validate checks that the rule is well formed
+func (r SmoothingRule) validate() error {
+	switch r.Method {
+	case SmoothingEMA:
+		if r.Alpha <= 0 || r.Alpha > 1 {
+			return fmt.Errorf("ema smoothing alpha must be in (0, 1], got %v", r.Alpha)
+		}
+	case SmoothingTWAP:
+		if window, err := time.ParseDuration(r.Window); err != nil || window <= 0 {
+			return fmt.Errorf("twap smoothing window must be a positive duration, got %q", r.Window)
+		}
+	default:
+		return fmt.Errorf("unknown smoothing method %q", r.Method)
+	}
+	return nil
+}
+
+func (r SmoothingRule) appliesTo(symbol string, rate exchangeRate) bool {
+	if len(r.Symbols) > 0 && !containsString(r.Symbols, symbol) {
+		return false
+	}
+	if len(r.Types) > 0 && !containsString(r.Types, rate.Type) {
+		return false
+	}
+	return true
+}
+
+func (r SmoothingRule) window() time.Duration {
+	window, _ := time.ParseDuration(r.Window)
+	return window
+}
+
+// rawPrices are the prices of a rate before it was smoothed
+type rawPrices struct {
+	Ask  json.Number `json:"ask"`
+	Bid  json.Number `json:"bid"`
+	Last json.Number `json:"last"`
+}
+
+// rateHistory holds the raw and smoothed prices of the smoothed symbols in
+// recent runs, oldest first. It is published as the history artifact so the
+// next run can read it.
+type rateHistory struct {
+	Runs []historyRun `json:"runs"`
+}
+
+// historyRun holds the ask, bid and last prices of each smoothed symbol in a
+// run
+type historyRun struct {
+	At       time.Time             `json:"at"`
+	Raw      map[string][3]float64 `json:"raw"`
+	Smoothed map[string][3]float64 `json:"smoothed"`
+}
+
+// latest returns the most recent run, if any
+func (h *rateHistory) latest() (historyRun, bool) {
+	if len(h.Runs) == 0 {
+		return historyRun{}, false
+	}
+	return h.Runs[len(h.Runs)-1], true
+}
+
+// add appends the run and forgets runs older than maxAge, always keeping the
+// latest for ema rules
+func (h *rateHistory) add(run historyRun, maxAge time.Duration) {
+	h.Runs = append(h.Runs, run)
+	cutoff := run.At.Add(-maxAge)
+	for len(h.Runs) > 1 && (h.Runs[0].At.Before(cutoff) || len(h.Runs) > rateHistoryMaxRuns) {
+		h.Runs = h.Runs[1:]
+	}
+}
+
+// artifact serializes the history as the history artifact
+func (h *rateHistory) artifact() (Artifact, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{Name: "history", Data: data}, nil
+}
+
+// loadRateHistory reads the last published history. It is empty if nothing has
+// been published yet.
+func loadRateHistory(conf Config) (*rateHistory, error) {
+	history := &rateHistory{Runs: []historyRun{}}
+	data, err := readResource(conf, publishedLocation(conf, "history"))
+	if isResourceNotFound(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// loadSmoothingHistory loads the history when smoothing rules are configured
+// and returns nil otherwise
+func loadSmoothingHistory(conf Config) (*rateHistory, error) {
+	if len(conf.Smoothing) == 0 {
+		return nil, nil
+	}
+	return loadRateHistory(conf)
+}
+
+// smoothRates replaces the prices of the symbols covered by the rules with
+// their smoothed prices, keeping the raw ones on the rate, and records the run
+// in the history. The first rule that applies to a symbol is used.
+func smoothRates(job *health.Job, rules []SmoothingRule, rates exchangeRates, history *rateHistory, now time.Time) {
+	symbols := make([]string, 0, len(rates))
+	for symbol := range rates {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	previous, _ := history.latest()
+	run := historyRun{At: now, Raw: map[string][3]float64{}, Smoothed: map[string][3]float64{}}
+	for _, symbol := range symbols {
+		rate := rates[symbol]
+		var rule *SmoothingRule
+		for i := range rules {
+			if rules[i].appliesTo(symbol, rate) {
+				rule = &rules[i]
+				break
+			}
+		}
+		if rule == nil {
+			continue
+		}
+
+		raw, err := parseRatePrices(rate)
+		if err != nil || !finitePrices(raw) {
+			continue
+		}
+
+		smoothed := raw
+		switch rule.Method {
+		case SmoothingEMA:
+			if last, ok := previous.Smoothed[symbol]; ok {
+				for i := range smoothed {
+					smoothed[i] = rule.Alpha*raw[i] + (1-rule.Alpha)*last[i]
+				}
+			}
+		case SmoothingTWAP:
+			smoothed = timeWeightedAverage(history, symbol, raw, now, rule.window())
+		}
+		run.Raw[symbol] = raw
+		run.Smoothed[symbol] = smoothed
+
+		rate.Raw = &rawPrices{Ask: rate.Ask, Bid: rate.Bid, Last: rate.Last}
+		rate.Ask = formatDerivedPrice(smoothed[0])
+		rate.Bid = formatDerivedPrice(smoothed[1])
+		rate.Last = formatDerivedPrice(smoothed[2])
+		rate.Smoothing = rule.Method
+		rates[symbol] = rate
+	}
+
+	history.add(run, maxSmoothingWindow(rules))
+	job.EventKv("smooth", health.Kvs{"smoothed": strconv.Itoa(len(run.Smoothed))})
+}
+
+// timeWeightedAverage averages the raw prices of a symbol in the runs within
+// the window and the current ones. Each price is weighted by the time since the
+// run before it, or since the start of the window for the oldest.
+func timeWeightedAverage(history *rateHistory, symbol string, current [3]float64, now time.Time, window time.Duration) [3]float64 {
+	start := now.Add(-window)
+	previous := start
+	var sums [3]float64
+	var total float64
+
+	addSample := func(at time.Time, prices [3]float64) {
+		weight := at.Sub(previous).Seconds()
+		if weight <= 0 {
+			return
+		}
+		for i := range sums {
+			sums[i] += prices[i] * weight
+		}
+		total += weight
+		previous = at
+	}
+	for _, run := range history.Runs {
+		if prices, ok := run.Raw[symbol]; ok && run.At.After(start) && run.At.Before(now) {
+			addSample(run.At, prices)
+		}
+	}
+	addSample(now, current)
+
+	if total == 0 {
+		return current
+	}
+	for i := range sums {
+		sums[i] /= total
+	}
+	return sums
+}
+
+// finitePrices checks that the prices can be stored in the history
+func finitePrices(prices [3]float64) bool {
+	for _, price := range prices {
+		if math.IsInf(price, 0) || math.IsNaN(price) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxSmoothingWindow returns the longest twap window of the rules
+func maxSmoothingWindow(rules []SmoothingRule) time.Duration {
+	var longest time.Duration
+	for _, rule := range rules {
+		if rule.Method == SmoothingTWAP && rule.window() > longest {
+			longest = rule.window()
+		}
+	}
+	return longest
+}
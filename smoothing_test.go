@@ -0,0 +1,116 @@
+package ticker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestSmoothRates(t *testing.T) {
+	job := health.NewStream().NewJob("fetch")
+	rules := []SmoothingRule{
+		{Method: SmoothingEMA, Symbols: []string{"FOO"}, Alpha: 0.25},
+		{Method: SmoothingTWAP, Types: []string{"crypto"}, Window: "10m"},
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := &rateHistory{}
+
+	run := func(at time.Duration, foo string, bar string) exchangeRates {
+		rates := exchangeRates{
+			"FOO": {Ask: json.Number(foo), Bid: json.Number(foo), Last: json.Number(foo), Type: "crypto"},
+			"BAR": {Ask: json.Number(bar), Bid: json.Number(bar), Last: json.Number(bar), Type: "crypto"},
+			"USD": {Ask: "100", Bid: "100", Last: "100", Type: "fiat"},
+		}
+		smoothRates(job, rules, rates, history, start.Add(at))
+		return rates
+	}
+
+	rates := run(0, "100", "10")
+	if rates["FOO"].Last != "100" || rates["BAR"].Last != "10" {
+		t.Fatal("Expected the first run to be unchanged:", rates)
+	}
+
+	rates = run(time.Minute, "200", "20")
+	if rates["FOO"].Last != "125" || rates["FOO"].Smoothing != SmoothingEMA || rates["FOO"].Raw.Last != "200" {
+		t.Fatal("Incorrect ema:", rates["FOO"])
+	}
+
+	// BAR was 10 for the 9 minutes from the start of the window, then 20
+	if rates["BAR"].Last != "11" || rates["BAR"].Smoothing != SmoothingTWAP {
+		t.Fatal("Incorrect twap:", rates["BAR"])
+	}
+	if rates["USD"].Raw != nil || rates["USD"].Last != "100" {
+		t.Fatal("Smoothed a symbol no rule applies to:", rates["USD"])
+	}
+
+	rates = run(time.Hour, "200", "20")
+	if rates["BAR"].Last != "20" {
+		t.Fatal("Expected runs outside the window to be ignored:", rates["BAR"])
+	}
+	if len(history.Runs) != 1 {
+		t.Fatal("Expected runs outside the window to be forgotten:", len(history.Runs))
+	}
+}
+
+func TestFetchWithSmoothing(t *testing.T) {
+	provider := fakeprovider.New(1, 0)
+	server := httptest.NewServer(provider)
+	defer server.Close()
+
+	outPath, err := ioutil.TempDir("", "ticker_proxy_smoothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outPath)
+
+	conf := DefaultConfig()
+	conf.OutPath = outPath
+	conf.BTCAVGBaseURL = server.URL
+	conf.Providers = []string{"btcavg"}
+	conf.Smoothing = []SmoothingRule{{Method: SmoothingEMA, Symbols: []string{"USD"}, Alpha: 0.5}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	writer := NewFileSystemWriter(outPath)
+
+	err = Fetch(health.NewStream(), conf, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.SetScenarios(fakeprovider.ScenarioRandomWalk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Fetch(health.NewStream(), conf, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := loadRateHistory(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Runs) != 1 || len(history.Runs[0].Raw) != 1 {
+		t.Fatal("Incorrect history:", history)
+	}
+
+	data, err := ioutil.ReadFile(outPath + "/api_v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usd := extended["USD"]
+	if usd.Smoothing != SmoothingEMA || usd.Raw == nil || usd.Raw.Last == usd.Last {
+		t.Fatal("Expected the smoothed and raw prices:", usd)
+	}
+}
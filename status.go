@@ -0,0 +1,122 @@
+package ticker
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+// staticRateSource is the source reported for rates that aren't fetched, i.e.
+// BTC itself
+const staticRateSource = "static"
+
+// Status describes the outcome of a run. It is published as the status
+// artifact so consumers can tell when the published rates may be stale.
+type Status struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	OK          bool      `json:"ok"`
+	Error       string    `json:"error,omitempty"`
+
+	// LastSuccessAt is when a run last published rates, if ever
+	LastSuccessAt *time.Time `json:"last_success_at"`
+
+	Providers []ProviderStatus `json:"providers"`
+
+	// RequiredSources maps each required symbol to the source its published
+	// rate came from. Required symbols without a rate are listed in Missing.
+	RequiredSources map[string]string `json:"required_sources"`
+	Missing         []string          `json:"missing,omitempty"`
+
+	// ValidationReport holds the symbols filtered by the market floors and
+	// dropped by validation rules, which are published as warnings, and the
+	// failures that stopped the run
+	ValidationReport
+}
+
+// ProviderStatus describes how fetching from a single provider went
+type ProviderStatus struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Symbols   int    `json:"symbols"`
+}
+
+// newStatus creates the Status for a run, carrying over when the last
+// successful run happened from the previously published status
+func newStatus(job *health.Job, conf Config) *Status {
+	status := &Status{
+		GeneratedAt:     time.Now().UTC(),
+		Providers:       []ProviderStatus{},
+		RequiredSources: map[string]string{},
+	}
+
+	previous, err := loadPublishedStatus(conf)
+	if err != nil {
+		// The rates can still be published without the previous status
+		job.EventErr("load_published_status", err)
+	} else if previous != nil {
+		status.LastSuccessAt = previous.LastSuccessAt
+	}
+
+	return status
+}
+
+// setRates records which source each required symbol was published from
+func (s *Status) setRates(rates exchangeRates) {
+	s.RequiredSources = map[string]string{}
+	s.Missing = nil
+	for _, symbol := range CurrentSymbolPolicy().Required() {
+		rate, ok := rates[symbol]
+		if !ok {
+			s.Missing = append(s.Missing, symbol)
+			continue
+		}
+		s.RequiredSources[symbol] = rate.Source
+	}
+	sort.Strings(s.Missing)
+}
+
+// succeed marks the run as having published rates
+func (s *Status) succeed() {
+	s.OK = true
+	s.Error = ""
+	lastSuccessAt := s.GeneratedAt
+	s.LastSuccessAt = &lastSuccessAt
+}
+
+// fail marks the run as failed with the given error
+func (s *Status) fail(err error) {
+	s.OK = false
+	s.Error = err.Error()
+}
+
+// artifact serializes the status as the status artifact
+func (s *Status) artifact() (Artifact, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{Name: "status", Data: data}, nil
+}
+
+// loadPublishedStatus reads the last published status document. It returns nil
+// if nothing has been published yet.
+func loadPublishedStatus(conf Config) (*Status, error) {
+	data, err := readResource(conf, publishedLocation(conf, "status"))
+	if isResourceNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{}
+	err = json.Unmarshal(data, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
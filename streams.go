@@ -0,0 +1,790 @@
+package ticker
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocraft/health"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	krakenDefaultStreamURL   = "wss://ws.kraken.com"
+	coinbaseDefaultStreamURL = "wss://ws-feed.exchange.coinbase.com"
+
+	// streamReadTimeout is how long a feed may go quiet before it is treated
+	// as a gap and reconnected. Both exchanges send heartbeats every second.
+	streamReadTimeout = 15 * time.Second
+
+	streamMaxBackoff = time.Minute
+
+	// streamMaxMessageSize bounds the size of a single feed message
+	streamMaxMessageSize = 1 << 20
+
+	streamHandshakeTimeout = 10 * time.Second
+
+	// krakenBookDepth is the number of levels on each side of the Kraken books
+	// subscribed to, which their checksums cover
+	krakenBookDepth = 10
+)
+
+// streamMinBackoff is how long a dropped feed waits before reconnecting the
+// first time
+var streamMinBackoff = time.Second
+
+// StreamConfig configures the exchange WebSocket ticker feeds read in daemon
+// and serve mode. Feeds subscribe to the markets in kraken_pairs and
+// coinbase_products.
+type StreamConfig struct {
+	// Exchanges lists the feeds to subscribe to: kraken and coinbase
+	Exchanges []string `json:"exchanges"`
+
+	KrakenURL   string `json:"kraken_url"`
+	CoinbaseURL string `json:"coinbase_url"`
+
+	// MaxAge is how long a top of book is used for after its last update
+	MaxAge string `json:"max_age"`
+}
+
+// DefaultStreamConfig returns the stream config used when none is configured
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{Exchanges: []string{}, MaxAge: "30s"}
+}
+
+// enabled checks if any feeds are configured
+func (c StreamConfig) enabled() bool {
+	return len(c.Exchanges) > 0
+}
+
+// validate checks that the stream config is well formed
+func (c StreamConfig) validate() error {
+	for _, exchange := range c.Exchanges {
+		if exchange != "kraken" && exchange != "coinbase" {
+			return fmt.Errorf("stream exchanges must be kraken or coinbase, got %q", exchange)
+		}
+	}
+	for _, streamURL := range []string{c.KrakenURL, c.CoinbaseURL} {
+		if streamURL == "" {
+			continue
+		}
+		if u, err := url.Parse(streamURL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("stream URLs must be ws or wss URLs, got %q", streamURL)
+		}
+	}
+	if maxAge, err := time.ParseDuration(c.MaxAge); err != nil || maxAge <= 0 {
+		return fmt.Errorf("stream max_age must be a positive duration, got %q", c.MaxAge)
+	}
+	return nil
+}
+
+func (c StreamConfig) maxAge() time.Duration {
+	maxAge, _ := time.ParseDuration(c.MaxAge)
+	return maxAge
+}
+
+// topOfBook is the best ask and bid of a market and its last trade price
+type topOfBook struct {
+	base      string
+	quote     string
+	ask       json.Number
+	bid       json.Number
+	last      json.Number
+	sequence  int64
+	updatedAt time.Time
+}
+
+// orderBooks holds the top of book of every market fed by the streams, keyed
+// by exchange and then market
+type orderBooks struct {
+	mu    sync.RWMutex
+	books map[string]map[string]topOfBook
+}
+
+func newOrderBooks() *orderBooks {
+	return &orderBooks{books: map[string]map[string]topOfBook{}}
+}
+
+// update replaces the top of book of a market. Updates with a sequence number
+// older than the current one arrived out of order and are ignored.
+func (b *orderBooks) update(exchange string, book topOfBook) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.books[exchange] == nil {
+		b.books[exchange] = map[string]topOfBook{}
+	}
+	market := book.base + "/" + book.quote
+	if current, ok := b.books[exchange][market]; ok && book.sequence != 0 && book.sequence <= current.sequence {
+		return
+	}
+	book.updatedAt = time.Now()
+	b.books[exchange][market] = book
+}
+
+// remove forgets the book of a market, which can't be trusted after a gap in
+// its updates
+func (b *orderBooks) remove(exchange string, base string, quote string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.books[exchange], base+"/"+quote)
+}
+
+// reset forgets the books of an exchange, which can't be trusted after a gap
+// in its feed
+func (b *orderBooks) reset(exchange string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.books, exchange)
+}
+
+// rates returns the rates of the books updated within maxAge. When several
+// markets give a rate for the same symbol, the most recently updated one wins.
+func (b *orderBooks) rates(maxAge time.Duration) (exchangeRates, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	exchanges := make([]string, 0, len(b.books))
+	for exchange := range b.books {
+		exchanges = append(exchanges, exchange)
+	}
+	sort.Strings(exchanges)
+
+	rates := exchangeRates{}
+	updatedAt := map[string]time.Time{}
+	for _, exchange := range exchanges {
+		for _, book := range b.books[exchange] {
+			if time.Since(book.updatedAt) > maxAge {
+				continue
+			}
+			symbol, rate, ok, err := pairRate(book.base, book.quote, book.ask, book.bid, book.last)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || book.updatedAt.Before(updatedAt[symbol]) {
+				continue
+			}
+			rate.Path = []string{exchange + ":" + book.base + "/" + book.quote}
+			rates[symbol] = rate
+			updatedAt[symbol] = book.updatedAt
+		}
+	}
+	return rates, nil
+}
+
+var (
+	streamBooksMu sync.RWMutex
+	streamBooks   *orderBooks
+)
+
+func currentStreamBooks() *orderBooks {
+	streamBooksMu.RLock()
+	defer streamBooksMu.RUnlock()
+	return streamBooks
+}
+
+func setStreamBooks(books *orderBooks) {
+	streamBooksMu.Lock()
+	defer streamBooksMu.Unlock()
+	streamBooks = books
+}
+
+// streamsRunning checks if the stream provider has books to read
+func streamsRunning() bool {
+	return currentStreamBooks() != nil
+}
+
+// NewStreamFetcher returns the rates of the top of book of every streamed
+// market updated within maxAge. It doesn't make any requests.
+func NewStreamFetcher(maxAge time.Duration) fetchFn {
+	return func(job *health.Job, _ *http.Client) (exchangeRates, error) {
+		books := currentStreamBooks()
+		if books == nil {
+			return exchangeRates{}, nil
+		}
+		return books.rates(maxAge)
+	}
+}
+
+// StartStreams subscribes to the configured exchange feeds in the background
+// and makes their books available to the stream provider until stop is
+// closed. Feeds are reconnected with backoff when they drop or go quiet.
+func StartStreams(stream *health.Stream, conf Config, stop <-chan struct{}) {
+	if !conf.Stream.enabled() {
+		return
+	}
+
+	books := newOrderBooks()
+	setStreamBooks(books)
+
+	wg := sync.WaitGroup{}
+	for _, exchange := range conf.Stream.Exchanges {
+		wg.Add(1)
+		go func(exchange string) {
+			defer wg.Done()
+			runFeed(stream, exchange, func() tickerFeed { return newTickerFeed(exchange, conf) }, books, stop)
+		}(exchange)
+	}
+
+	go func() {
+		wg.Wait()
+		setStreamBooks(nil)
+	}()
+}
+
+// tickerFeed is an exchange's WebSocket ticker feed. A feed keeps the state of
+// a single connection, so one is created for each.
+type tickerFeed interface {
+	exchange() string
+	url() string
+
+	// subscriptions are the messages sent after connecting
+	subscriptions() ([][]byte, error)
+
+	// handle applies a message from the feed to the books. A gap in the
+	// updates of a market is returned as an errStreamGap after its book has
+	// been removed.
+	handle(books *orderBooks, message []byte) error
+
+	// resubscribe returns the messages that resubscribe to a market after a
+	// gap
+	resubscribe(market string) ([][]byte, error)
+}
+
+func newTickerFeed(exchange string, conf Config) tickerFeed {
+	if exchange == "kraken" {
+		streamURL := conf.Stream.KrakenURL
+		if streamURL == "" {
+			streamURL = krakenDefaultStreamURL
+		}
+		return &krakenFeed{
+			streamURL: streamURL,
+			pairs:     conf.KrakenPairs,
+			books:     map[string]*krakenBook{},
+			lasts:     map[string]json.Number{},
+		}
+	}
+
+	streamURL := conf.Stream.CoinbaseURL
+	if streamURL == "" {
+		streamURL = coinbaseDefaultStreamURL
+	}
+	return &coinbaseFeed{streamURL: streamURL, products: conf.CoinbaseProducts, lastTradeIDs: map[string]int64{}}
+}
+
+// runFeed reads the exchange's feed until stop is closed, reconnecting with
+// exponential backoff. The backoff is reset once a connection has stayed up for
+// a while.
+func runFeed(stream *health.Stream, exchange string, newFeed func() tickerFeed, books *orderBooks, stop <-chan struct{}) {
+	kvs := health.Kvs{"exchange": exchange}
+	backoff := streamMinBackoff
+	for {
+		connectedAt := time.Now()
+		err := readFeed(stream, newFeed(), books, stop)
+		books.reset(exchange)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		stream.EventErrKv("stream.disconnect", err, kvs)
+
+		if time.Since(connectedAt) > streamMaxBackoff {
+			backoff = streamMinBackoff
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// readFeed connects to the feed, subscribes and applies its messages until the
+// connection fails or stop is closed. Markets with a gap in their updates are
+// resubscribed to without reconnecting.
+func readFeed(stream *health.Stream, feed tickerFeed, books *orderBooks, stop <-chan struct{}) error {
+	conn, err := dialFeed(feed.url())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	subscriptions, err := feed.subscriptions()
+	if err != nil {
+		return err
+	}
+	if err := writeFeedMessages(conn, subscriptions); err != nil {
+		return err
+	}
+	stream.EventKv("stream.connect", health.Kvs{"exchange": feed.exchange()})
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		err = feed.handle(books, message)
+		if gap, ok := err.(errStreamGap); ok {
+			stream.EventErrKv("stream.gap", gap, health.Kvs{"exchange": feed.exchange(), "market": gap.market})
+			messages, err := feed.resubscribe(gap.market)
+			if err != nil {
+				return err
+			}
+			if err := writeFeedMessages(conn, messages); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dialFeed opens a WebSocket connection to a feed
+func dialFeed(streamURL string) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: streamHandshakeTimeout,
+	}
+	conn, resp, err := dialer.Dial(streamURL, nil)
+	if err == websocket.ErrBadHandshake && resp != nil {
+		return nil, errUnexpectedStatus{resp.Request.URL.Host, resp.StatusCode}
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(streamMaxMessageSize)
+	return conn, nil
+}
+
+func writeFeedMessages(conn *websocket.Conn, messages [][]byte) error {
+	for _, message := range messages {
+		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coinbaseFeed is the Coinbase Exchange ticker channel. Ticker sequence numbers
+// skip the messages of other channels, so gaps are found with the heartbeat
+// channel instead: each heartbeat carries the product's last trade ID, which
+// is later than the last ticker's when tickers were missed.
+type coinbaseFeed struct {
+	streamURL string
+	products  []string
+
+	// lastTradeIDs is the trade ID of the last ticker of each product
+	lastTradeIDs map[string]int64
+}
+
+var coinbaseFeedChannels = []string{"ticker", "heartbeat"}
+
+type coinbaseFeedMessage struct {
+	Type        string      `json:"type"`
+	ProductID   string      `json:"product_id"`
+	Sequence    int64       `json:"sequence"`
+	TradeID     int64       `json:"trade_id"`
+	LastTradeID int64       `json:"last_trade_id"`
+	Price       json.Number `json:"price"`
+	BestBid     json.Number `json:"best_bid"`
+	BestAsk     json.Number `json:"best_ask"`
+	Message     string      `json:"message"`
+	Reason      string      `json:"reason"`
+}
+
+func (f *coinbaseFeed) exchange() string { return "coinbase" }
+func (f *coinbaseFeed) url() string      { return f.streamURL }
+
+func (f *coinbaseFeed) subscriptions() ([][]byte, error) {
+	subscription, err := coinbaseFeedRequest("subscribe", f.products)
+	return [][]byte{subscription}, err
+}
+
+func (f *coinbaseFeed) resubscribe(product string) ([][]byte, error) {
+	unsubscribe, err := coinbaseFeedRequest("unsubscribe", []string{product})
+	if err != nil {
+		return nil, err
+	}
+	subscribe, err := coinbaseFeedRequest("subscribe", []string{product})
+	return [][]byte{unsubscribe, subscribe}, err
+}
+
+func coinbaseFeedRequest(requestType string, products []string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        requestType,
+		"product_ids": products,
+		"channels":    coinbaseFeedChannels,
+	})
+}
+
+func (f *coinbaseFeed) handle(books *orderBooks, message []byte) error {
+	msg := &coinbaseFeedMessage{}
+	if err := json.Unmarshal(message, msg); err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(msg.ProductID, "-", 2)
+	switch msg.Type {
+	case "error":
+		return errProviderResponse{"coinbase", msg.Message + ": " + msg.Reason}
+	case "ticker":
+		if len(parts) != 2 {
+			return nil
+		}
+		if lastTradeID, ok := f.lastTradeIDs[msg.ProductID]; !ok || msg.TradeID > lastTradeID {
+			f.lastTradeIDs[msg.ProductID] = msg.TradeID
+		}
+		books.update("coinbase", topOfBook{
+			base:     CanonicalizeSymbol(parts[0]),
+			quote:    CanonicalizeSymbol(parts[1]),
+			ask:      msg.BestAsk,
+			bid:      msg.BestBid,
+			last:     msg.Price,
+			sequence: msg.Sequence,
+		})
+	case "heartbeat":
+		lastTradeID, ok := f.lastTradeIDs[msg.ProductID]
+		if !ok || len(parts) != 2 || msg.LastTradeID <= lastTradeID {
+			return nil
+		}
+		delete(f.lastTradeIDs, msg.ProductID)
+		books.remove("coinbase", CanonicalizeSymbol(parts[0]), CanonicalizeSymbol(parts[1]))
+		return errStreamGap{msg.ProductID, fmt.Sprintf("missed the tickers of trades %d to %d", lastTradeID+1, msg.LastTradeID)}
+	}
+	return nil
+}
+
+// krakenFeed is the Kraken ticker and book channels. The ask and bid come from
+// the book, which is checked against the checksum of every update, and the
+// last price from the ticker. Kraken sends a heartbeat every second when there
+// are no updates.
+type krakenFeed struct {
+	streamURL string
+	pairs     []string
+
+	// books and lasts are the book and last price of each pair by its
+	// WebSocket name
+	books map[string]*krakenBook
+	lasts map[string]json.Number
+}
+
+type krakenFeedEvent struct {
+	Event        string `json:"event"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+type krakenFeedTicker struct {
+	C []json.Number `json:"c"`
+}
+
+// krakenFeedBook is a book snapshot (as and bs) or update (a, b and the
+// checksum c). Levels are the price, volume and time, and updates to both sides
+// may come in separate objects.
+type krakenFeedBook struct {
+	AS [][]string `json:"as"`
+	BS [][]string `json:"bs"`
+	A  [][]string `json:"a"`
+	B  [][]string `json:"b"`
+	C  string     `json:"c"`
+}
+
+func (f *krakenFeed) exchange() string { return "kraken" }
+func (f *krakenFeed) url() string      { return f.streamURL }
+
+// subscriptions subscribes to the pairs by their WebSocket names, e.g. XBT/USD
+func (f *krakenFeed) subscriptions() ([][]byte, error) {
+	names := make([]string, 0, len(f.pairs))
+	for _, pair := range f.pairs {
+		base, quote, ok := parseKrakenPair(pair)
+		if !ok {
+			return nil, errInvalidConfig(fmt.Sprintf("unsupported kraken pair %q", pair))
+		}
+		names = append(names, krakenAssetCode(base)+"/"+krakenAssetCode(quote))
+	}
+
+	ticker, err := krakenFeedRequest("subscribe", names, map[string]interface{}{"name": "ticker"})
+	if err != nil {
+		return nil, err
+	}
+	book, err := krakenFeedRequest("subscribe", names, krakenFeedBookSubscription())
+	return [][]byte{ticker, book}, err
+}
+
+// resubscribe resubscribes to the book of a pair, which starts with a fresh
+// snapshot
+func (f *krakenFeed) resubscribe(pair string) ([][]byte, error) {
+	unsubscribe, err := krakenFeedRequest("unsubscribe", []string{pair}, krakenFeedBookSubscription())
+	if err != nil {
+		return nil, err
+	}
+	subscribe, err := krakenFeedRequest("subscribe", []string{pair}, krakenFeedBookSubscription())
+	return [][]byte{unsubscribe, subscribe}, err
+}
+
+func krakenFeedBookSubscription() map[string]interface{} {
+	return map[string]interface{}{"name": "book", "depth": krakenBookDepth}
+}
+
+func krakenFeedRequest(event string, pairs []string, subscription map[string]interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"event":        event,
+		"pair":         pairs,
+		"subscription": subscription,
+	})
+}
+
+// handle applies channel updates, which are arrays of the channel ID, one or
+// more payloads, the channel name and the pair. Other messages are event
+// objects.
+func (f *krakenFeed) handle(books *orderBooks, message []byte) error {
+	update := []json.RawMessage{}
+	if err := json.Unmarshal(message, &update); err != nil {
+		event := &krakenFeedEvent{}
+		if err := json.Unmarshal(message, event); err != nil {
+			return err
+		}
+		if event.Status == "error" {
+			return errProviderResponse{"kraken", event.ErrorMessage}
+		}
+		return nil
+	}
+
+	if len(update) < 4 {
+		return nil
+	}
+	var channel, pair string
+	if err := json.Unmarshal(update[len(update)-2], &channel); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(update[len(update)-1], &pair); err != nil {
+		return err
+	}
+	assets := strings.SplitN(pair, "/", 2)
+	if len(assets) != 2 {
+		return nil
+	}
+	payloads := update[1 : len(update)-2]
+
+	switch {
+	case channel == "ticker":
+		ticker := &krakenFeedTicker{}
+		if err := json.Unmarshal(payloads[0], ticker); err != nil {
+			return err
+		}
+		if len(ticker.C) == 0 {
+			return nil
+		}
+		f.lasts[pair] = ticker.C[0]
+	case strings.HasPrefix(channel, "book"):
+		if err := f.applyBook(pair, payloads); err != nil {
+			delete(f.books, pair)
+			books.remove("kraken", krakenSymbol(assets[0]), krakenSymbol(assets[1]))
+			return err
+		}
+	default:
+		return nil
+	}
+
+	book, last := f.books[pair], f.lasts[pair]
+	if book == nil || last == "" {
+		return nil
+	}
+	ask, bid := book.best()
+	if ask == "" || bid == "" {
+		return nil
+	}
+	books.update("kraken", topOfBook{
+		base:  krakenSymbol(assets[0]),
+		quote: krakenSymbol(assets[1]),
+		ask:   json.Number(ask),
+		bid:   json.Number(bid),
+		last:  last,
+	})
+	return nil
+}
+
+// applyBook applies the payloads of a book message to the pair's book. An
+// update that doesn't match its checksum, or that comes before a snapshot,
+// means updates were missed and is returned as an errStreamGap.
+func (f *krakenFeed) applyBook(pair string, payloads []json.RawMessage) error {
+	var checksum string
+	for _, payload := range payloads {
+		msg := &krakenFeedBook{}
+		if err := json.Unmarshal(payload, msg); err != nil {
+			return err
+		}
+		if msg.AS != nil || msg.BS != nil {
+			f.books[pair] = newKrakenBook()
+			msg.A, msg.B = msg.AS, msg.BS
+		}
+
+		book := f.books[pair]
+		if book == nil {
+			return errStreamGap{pair, "book update before a snapshot"}
+		}
+		if err := book.apply(msg.A, msg.B); err != nil {
+			return err
+		}
+		if msg.C != "" {
+			checksum = msg.C
+		}
+	}
+
+	if checksum == "" {
+		return nil
+	}
+	book := f.books[pair]
+	book.truncate(krakenBookDepth)
+	expected, err := strconv.ParseUint(checksum, 10, 32)
+	if err != nil {
+		return err
+	}
+	if actual := book.checksum(); actual != uint32(expected) {
+		return errStreamGap{pair, fmt.Sprintf("book checksum %d doesn't match %d", actual, expected)}
+	}
+	return nil
+}
+
+// krakenBook is the top levels of a Kraken order book. Levels are kept as the
+// price and volume strings Kraken sends, which its checksum is computed from.
+type krakenBook struct {
+	asks map[string]string
+	bids map[string]string
+}
+
+func newKrakenBook() *krakenBook {
+	return &krakenBook{asks: map[string]string{}, bids: map[string]string{}}
+}
+
+// apply sets the volume of each level, removing levels with a volume of 0
+func (b *krakenBook) apply(asks [][]string, bids [][]string) error {
+	for _, side := range []struct {
+		levels  map[string]string
+		updates [][]string
+	}{{b.asks, asks}, {b.bids, bids}} {
+		for _, level := range side.updates {
+			if len(level) < 2 {
+				return errProviderResponse{"kraken", "malformed book level"}
+			}
+			volume, err := strconv.ParseFloat(level[1], 64)
+			if err != nil {
+				return err
+			}
+			if volume == 0 {
+				delete(side.levels, level[0])
+				continue
+			}
+			side.levels[level[0]] = level[1]
+		}
+	}
+	return nil
+}
+
+// truncate drops the levels past the given depth, which Kraken stops sending
+// updates for
+func (b *krakenBook) truncate(depth int) {
+	for _, side := range []struct {
+		levels     map[string]string
+		descending bool
+	}{{b.asks, false}, {b.bids, true}} {
+		for _, price := range sortedKrakenPrices(side.levels, side.descending)[minInt(depth, len(side.levels)):] {
+			delete(side.levels, price)
+		}
+	}
+}
+
+// best returns the lowest ask and the highest bid
+func (b *krakenBook) best() (string, string) {
+	var ask, bid string
+	if asks := sortedKrakenPrices(b.asks, false); len(asks) > 0 {
+		ask = asks[0]
+	}
+	if bids := sortedKrakenPrices(b.bids, true); len(bids) > 0 {
+		bid = bids[0]
+	}
+	return ask, bid
+}
+
+// checksum computes Kraken's CRC32 checksum of the book: the prices and
+// volumes of the asks from the lowest, then the bids from the highest, with
+// the decimal point and leading zeros removed
+func (b *krakenBook) checksum() uint32 {
+	var buf strings.Builder
+	for _, side := range []struct {
+		levels     map[string]string
+		descending bool
+	}{{b.asks, false}, {b.bids, true}} {
+		for _, price := range sortedKrakenPrices(side.levels, side.descending) {
+			buf.WriteString(krakenChecksumDigits(price))
+			buf.WriteString(krakenChecksumDigits(side.levels[price]))
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(buf.String()))
+}
+
+func krakenChecksumDigits(value string) string {
+	return strings.TrimLeft(strings.Replace(value, ".", "", 1), "0")
+}
+
+// sortedKrakenPrices returns the prices of a side of the book from the best,
+// the lowest for asks and the highest for bids
+func sortedKrakenPrices(levels map[string]string, descending bool) []string {
+	prices := make([]string, 0, len(levels))
+	values := make(map[string]float64, len(levels))
+	for price := range levels {
+		prices = append(prices, price)
+		values[price], _ = strconv.ParseFloat(price, 64)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return values[prices[i]] > values[prices[j]]
+		}
+		return values[prices[i]] < values[prices[j]]
+	})
+	return prices
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// errStreamGap reports that updates to a market were missed
+type errStreamGap struct {
+	market string
+	reason string
+}
+
+func (e errStreamGap) Error() string {
+	return fmt.Sprintf("Gap in the updates of %s: %s", e.market, e.reason)
+}
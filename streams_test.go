@@ -0,0 +1,203 @@
+package ticker
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func init() {
+	streamMinBackoff = 10 * time.Millisecond
+}
+
+func TestOrderBooks(t *testing.T) {
+	books := newOrderBooks()
+	books.update("coinbase", topOfBook{base: "BTC", quote: "USD", ask: "101", bid: "99", last: "100", sequence: 2})
+	books.update("coinbase", topOfBook{base: "BTC", quote: "USD", ask: "91", bid: "89", last: "90", sequence: 1})
+	books.update("kraken", topOfBook{base: "ETH", quote: "BTC", ask: "0.05", bid: "0.04", last: "0.045"})
+
+	rates, err := books.rates(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["USD"].Last != "100" {
+		t.Fatal("Expected an out of order update to be ignored:", rates["USD"])
+	}
+	if rates["ETH"].Ask != "25" || rates["ETH"].Path[0] != "kraken:ETH/BTC" {
+		t.Fatal("Incorrect rate from a BTC quoted market:", rates["ETH"])
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	rates, err = books.rates(time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 0 {
+		t.Fatal("Expected stale books to be left out:", rates)
+	}
+
+	books.reset("kraken")
+	rates, _ = books.rates(time.Minute)
+	if _, ok := rates["ETH"]; ok {
+		t.Fatal("Expected the kraken books to be reset")
+	}
+}
+
+func TestStreams(t *testing.T) {
+	provider := fakeprovider.New(1, 0)
+	provider.StreamInterval = 10 * time.Millisecond
+	server := httptest.NewServer(provider)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.Providers = []string{"btcavg", "stream"}
+	conf.KrakenPairs = []string{"XBTUSD", "ETHXBT"}
+	conf.CoinbaseProducts = []string{"BTC-GBP", "LTC-BTC"}
+	conf.Stream = StreamConfig{
+		Exchanges:   []string{"kraken", "coinbase"},
+		KrakenURL:   wsURL + fakeprovider.KrakenStreamPath,
+		CoinbaseURL: wsURL + fakeprovider.CoinbaseStreamPath,
+		MaxAge:      "1m",
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &testEventSink{}
+	stream := health.NewStream()
+	stream.AddSink(sink)
+	stop := make(chan struct{})
+	StartStreams(stream, conf, stop)
+
+	waitForRates := func(symbols ...string) exchangeRates {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			rates, err := currentStreamBooks().rates(time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			missing := false
+			for _, symbol := range symbols {
+				if _, ok := rates[symbol]; !ok {
+					missing = true
+				}
+			}
+			if !missing {
+				return rates
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for streamed rates:", symbols)
+		return nil
+	}
+	waitForRates("USD", "ETH", "GBP", "LTC")
+
+	writer := NewMemoryWriter()
+	err := Fetch(stream, conf, writer.Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(writer.artifacts["api_v2"].Data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended["GBP"].Source != "stream" || extended["GBP"].Path[0] != "coinbase:BTC/GBP" {
+		t.Fatal("Incorrect streamed rate:", extended["GBP"])
+	}
+
+	// Gaps in the updates of a market are recovered from by resubscribing to
+	// it, without reconnecting
+	provider.SkipStreamUpdates("kraken", 1)
+	provider.SkipStreamUpdates("coinbase", 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for countTestEvents(sink, "stream.gap.err") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the gaps to be noticed", sink.events)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitForRates("USD", "ETH", "GBP", "LTC")
+	if countTestEvents(sink, "stream.connect") != 2 {
+		t.Fatal("Expected the feeds to resubscribe rather than reconnect")
+	}
+
+	// Dropped feeds reconnect and refill their books
+	provider.DropStreams()
+	deadline = time.Now().Add(5 * time.Second)
+	for countTestEvents(sink, "stream.connect") < 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the feeds to reconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if countTestEvents(sink, "stream.disconnect.err") < 2 {
+		t.Fatal("Expected disconnects to be reported")
+	}
+	waitForRates("USD", "GBP")
+
+	close(stop)
+	deadline = time.Now().Add(5 * time.Second)
+	for streamsRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the feeds to stop")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKrakenFeedChecksum(t *testing.T) {
+	feed := newTickerFeed("kraken", Config{KrakenPairs: []string{"XBTUSD"}}).(*krakenFeed)
+	books := newOrderBooks()
+	for _, message := range []string{
+		`[1,{"c":["100.0","0.1"]},"ticker","XBT/USD"]`,
+		`[2,{"as":[["101.0","1.0","1"],["102.0","2.0","1"]],"bs":[["99.0","1.0","1"],["98.0","2.0","1"]]},"book-10","XBT/USD"]`,
+		`[2,{"a":[["101.0","0.00000000","2"],["101.5","3.0","2"]]},{"b":[["99.5","1.5","2"]],"c":"` + krakenTestChecksum("1015", "30", "1020", "20", "995", "15", "990", "10", "980", "20") + `"},"book-10","XBT/USD"]`,
+	} {
+		if err := feed.handle(books, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rates, err := books.rates(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["USD"].Ask != "101.5" || rates["USD"].Bid != "99.5" || rates["USD"].Last != "100.0" {
+		t.Fatal("Incorrect rate from the book:", rates["USD"])
+	}
+
+	err = feed.handle(books, []byte(`[2,{"a":[["101.5","4.0","3"]],"c":"12345"},"book-10","XBT/USD"]`))
+	if gap, ok := err.(errStreamGap); !ok || gap.market != "XBT/USD" {
+		t.Fatal("Expected a checksum mismatch to be a gap, got:", err)
+	}
+	rates, _ = books.rates(time.Minute)
+	if _, ok := rates["USD"]; ok {
+		t.Fatal("Expected the book to be removed after a gap")
+	}
+}
+
+func krakenTestChecksum(digits ...string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(strings.Join(digits, "")))), 10)
+}
+
+func countTestEvents(sink *testEventSink, event string) int {
+	sink.Lock()
+	defer sink.Unlock()
+	count := 0
+	for _, e := range sink.events {
+		if e == event {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,143 @@
+package ticker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gocraft/health"
+)
+
+// FX triangulation modes
+const (
+	// FXModeOff doesn't derive any rates from the FX table
+	FXModeOff = "off"
+
+	// FXModeFallback derives fiat rates that no provider returned
+	FXModeFallback = "fallback"
+
+	// FXModeReplace derives every fiat rate the FX table covers, replacing the
+	// rates returned by providers
+	FXModeReplace = "replace"
+)
+
+// FXConfig configures how fiat rates are derived from a trusted BTC rate and
+// an FX reference table
+type FXConfig struct {
+	Mode string `json:"mode"`
+
+	// Anchor is the fiat symbol whose BTC rate is trusted: USD or EUR
+	Anchor string `json:"anchor"`
+}
+
+// DefaultFXConfig returns the FX config used when none is configured
+func DefaultFXConfig() FXConfig {
+	return FXConfig{Mode: FXModeOff, Anchor: "USD"}
+}
+
+// enabled checks if any rates are derived from the FX table
+func (c FXConfig) enabled() bool {
+	return c.Mode == FXModeFallback || c.Mode == FXModeReplace
+}
+
+// validate checks that the FX config is well formed
+func (c FXConfig) validate() error {
+	switch c.Mode {
+	case FXModeOff, FXModeFallback, FXModeReplace:
+	default:
+		return fmt.Errorf("fx mode must be off, fallback or replace, got %q", c.Mode)
+	}
+	if c.Anchor != "USD" && c.Anchor != "EUR" {
+		return fmt.Errorf("fx anchor must be USD or EUR, got %q", c.Anchor)
+	}
+	return nil
+}
+
+// triangulateFiat derives BTC->fiat rates from the anchor's BTC rate and the
+// FX table, which holds the units of each currency per EUR. Derived rates are
+// flagged and marked with the FX source and their derivation path. Rates can't
+// be derived when the anchor is missing from either the rates or the table.
+func triangulateFiat(job *health.Job, conf FXConfig, rates exchangeRates, table exchangeRates, tableSource string) {
+	anchorRate, ok := rates[conf.Anchor]
+	if !ok || anchorRate.Type != exchangeRateTypeFiat.String() {
+		job.EventErr("fx.triangulate", errMissingFXAnchor("no BTC rate for "+conf.Anchor))
+		return
+	}
+	anchorFX, ok := table[conf.Anchor]
+	if !ok {
+		job.EventErr("fx.triangulate", errMissingFXAnchor(conf.Anchor+" is not in the FX table"))
+		return
+	}
+
+	anchorPrices, err := parseRatePrices(anchorRate)
+	if err != nil {
+		job.EventErr("fx.triangulate", err)
+		return
+	}
+	anchorPerEUR, err := anchorFX.Last.Float64()
+	if err != nil || anchorPerEUR <= 0 {
+		job.EventErr("fx.triangulate", errInvalidFXTable("invalid rate for "+conf.Anchor))
+		return
+	}
+
+	symbols := make([]string, 0, len(table))
+	for symbol := range table {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	derived := 0
+	for _, symbol := range symbols {
+		if symbol == conf.Anchor {
+			continue
+		}
+		if _, exists := rates[symbol]; exists && conf.Mode != FXModeReplace {
+			continue
+		}
+
+		perEUR, err := table[symbol].Last.Float64()
+		if err != nil || perEUR <= 0 {
+			continue
+		}
+		cross := perEUR / anchorPerEUR
+
+		rates[symbol] = exchangeRate{
+			Ask:     formatDerivedPrice(anchorPrices[0] * cross),
+			Bid:     formatDerivedPrice(anchorPrices[1] * cross),
+			Last:    formatDerivedPrice(anchorPrices[2] * cross),
+			Type:    exchangeRateTypeFiat.String(),
+			Source:  tableSource,
+			Path:    append(ratePath(conf.Anchor, anchorRate), tableSource+":"+conf.Anchor+"/"+symbol),
+			Derived: true,
+		}
+		derived++
+	}
+
+	job.EventKv("fx.triangulate", health.Kvs{"mode": conf.Mode, "anchor": conf.Anchor, "derived": strconv.Itoa(derived)})
+}
+
+// parseRatePrices returns the ask, bid and last prices of the rate
+func parseRatePrices(rate exchangeRate) ([3]float64, error) {
+	prices := [3]float64{}
+	for i, price := range []string{rate.Ask.String(), rate.Bid.String(), rate.Last.String()} {
+		value, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			return prices, err
+		}
+		prices[i] = value
+	}
+	return prices, nil
+}
+
+// formatDerivedPrice formats a derived or smoothed price at full precision, as
+// fiat rates in the millions lose whole units at float32 precision
+func formatDerivedPrice(price float64) json.Number {
+	return json.Number(strconv.FormatFloat(price, 'f', -1, 64))
+}
+
+type errMissingFXAnchor string
+
+func (e errMissingFXAnchor) Error() string {
+	return "Missing FX anchor: " + string(e)
+}
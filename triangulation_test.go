@@ -0,0 +1,229 @@
+package ticker
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OpenBazaar/tickerproxy/internal/fakeprovider"
+	"github.com/gocraft/health"
+)
+
+func TestTriangulateFiat(t *testing.T) {
+	job := health.NewStream().NewJob("fetch")
+	table := exchangeRates{
+		"EUR": newFXRate("1"),
+		"USD": newFXRate("1.25"),
+		"GBP": newFXRate("0.5"),
+		"IDR": newFXRate("16250.123"),
+	}
+	newRates := func() exchangeRates {
+		return exchangeRates{
+			"USD": {Ask: "10000", Bid: "5000", Last: "7500", Type: "fiat", Source: "btcavg"},
+			"GBP": {Ask: "1", Bid: "1", Last: "1", Type: "fiat", Source: "btcavg"},
+		}
+	}
+
+	rates := newRates()
+	triangulateFiat(job, FXConfig{Mode: FXModeFallback, Anchor: "USD"}, rates, table, "ecb")
+	expectedEUR := exchangeRate{
+		Ask:     "8000",
+		Bid:     "4000",
+		Last:    "6000",
+		Type:    "fiat",
+		Source:  "ecb",
+		Path:    []string{"btcavg:BTC/USD", "ecb:USD/EUR"},
+		Derived: true,
+	}
+	if !reflect.DeepEqual(rates["EUR"], expectedEUR) {
+		t.Fatal("Incorrect derived rate:", rates["EUR"])
+	}
+	if rates["GBP"].Source != "btcavg" || rates["USD"].Source != "btcavg" {
+		t.Fatal("Fallback replaced fetched rates:", rates)
+	}
+
+	// Rates in the millions keep their precision
+	if last, err := strconv.ParseFloat(rates["IDR"].Last.String(), 64); err != nil || math.Abs(last-97500738) > 0.01 {
+		t.Fatal("Incorrect precision of a large derived rate:", rates["IDR"])
+	}
+
+	rates = newRates()
+	triangulateFiat(job, FXConfig{Mode: FXModeReplace, Anchor: "USD"}, rates, table, "ecb")
+	if rates["GBP"].Last != "3000" || rates["GBP"].Source != "ecb" || rates["USD"].Source != "btcavg" {
+		t.Fatal("Replace didn't derive every rate but the anchor:", rates)
+	}
+
+	// Nothing is derived without an anchor
+	rates = newRates()
+	triangulateFiat(job, FXConfig{Mode: FXModeReplace, Anchor: "EUR"}, rates, table, "ecb")
+	if !reflect.DeepEqual(rates, newRates()) {
+		t.Fatal("Rates were derived without an anchor:", rates)
+	}
+}
+
+func TestFetchWithECBTriangulation(t *testing.T) {
+	server := httptest.NewServer(fakeprovider.New(1, 0))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.ECBBaseURL = server.URL
+	conf.Providers = []string{"btcavg", "ecb"}
+	conf.FX = FXConfig{Mode: FXModeReplace, Anchor: "USD"}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := NewMemoryWriter()
+	err := Fetch(health.NewStream(), conf, writer.Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(writer.artifacts["api_v2"].Data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended["USD"].Source != "btcavg" || len(extended["USD"].Path) != 0 {
+		t.Fatal("Incorrect anchor rate:", extended["USD"])
+	}
+	gbp := extended["GBP"]
+	if gbp.Source != "ecb" || !reflect.DeepEqual(gbp.Path, []string{"btcavg:BTC/USD", "ecb:USD/GBP"}) {
+		t.Fatal("Incorrect derived rate:", gbp)
+	}
+	if extended["RUB"].Source != "btcavg" {
+		t.Fatal("Expected currencies missing from the FX table to keep their fetched rates:", extended["RUB"])
+	}
+
+	conf.Providers = []string{"btcavg"}
+	if _, ok := conf.Validate().(errInvalidConfig); !ok {
+		t.Fatal("Expected FX without the ecb provider to be invalid")
+	}
+}
+
+func TestFetchWithBTCAVGDown(t *testing.T) {
+	provider := fakeprovider.New(1, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/indices/") {
+			http.Error(rw, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		provider.ServeHTTP(rw, r)
+	}))
+	defer server.Close()
+
+	// ECB doesn't publish every required currency, e.g. RUB
+	err := SetSymbolPolicy(SymbolPolicy{
+		RequiredFiat:   []string{"USD", "EUR", "GBP"},
+		RequiredCrypto: []string{"BTC", "ETH"},
+		Pinned:         DefaultSymbolPolicy().Pinned,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSymbolPolicy(DefaultSymbolPolicy())
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.CMCBaseURL = server.URL
+	conf.CoinbaseBaseURL = server.URL
+	conf.ECBBaseURL = server.URL
+	conf.CoinbaseProducts = []string{"BTC-USD"}
+	conf.FX = FXConfig{Mode: FXModeFallback, Anchor: "USD"}
+	conf.OptionalProviders = []string{"btcavg"}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := NewMemoryWriter()
+	result, err := FetchWithResult(health.NewStream(), conf, NamedWriter{"memory", writer.Write})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Providers[0].Name != "btcavg" || result.Providers[0].OK || result.Providers[0].Error == "" {
+		t.Fatal("Expected the BTCAVG failure in its status:", result.Providers[0])
+	}
+
+	extended := map[string]extendedRate{}
+	err = json.Unmarshal(writer.artifacts["api_v2"].Data, &extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended["USD"].Source != "coinbase" {
+		t.Fatal("Incorrect anchor rate:", extended["USD"])
+	}
+	for _, symbol := range []string{"EUR", "GBP"} {
+		rate := extended[symbol]
+		if rate.Source != "ecb" || !reflect.DeepEqual(rate.Path, []string{"coinbase:BTC/USD", "ecb:USD/" + symbol}) {
+			t.Fatal("Incorrect derived rate:", symbol, rate)
+		}
+	}
+	if extended["ETH"].Source != "cmc" {
+		t.Fatal("Incorrect crypto rate:", extended["ETH"])
+	}
+}
+
+func TestFetchWithCMCDown(t *testing.T) {
+	cmcDown := false
+	provider := fakeprovider.New(1, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if cmcDown && strings.HasPrefix(r.URL.Path, "/v1/cryptocurrency/") {
+			http.Error(rw, "out of credits", http.StatusPaymentRequired)
+			return
+		}
+		provider.ServeHTTP(rw, r)
+	}))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.OutPath = ""
+	conf.BTCAVGBaseURL = server.URL
+	conf.CMCBaseURL = server.URL
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := NewMemoryWriter()
+	err := Fetch(health.NewStream(), conf, writer.Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := writer.artifacts["api"].Data
+
+	// A failed provider fails the run and only the status is written
+	cmcDown = true
+	err = Fetch(health.NewStream(), conf, writer.Write)
+	if err != (errUnexpectedStatus{"cmc", http.StatusPaymentRequired}) {
+		t.Fatal("Expected the CMC error, got:", err)
+	}
+	if !bytes.Equal(writer.artifacts["api"].Data, published) {
+		t.Fatal("Expected the published api to be left untouched")
+	}
+	status := Status{}
+	err = json.Unmarshal(writer.artifacts["status"].Data, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.OK {
+		t.Fatal("Expected a failed status:", status)
+	}
+
+	// Optional providers can fail without failing the run
+	conf.OptionalProviders = []string{"cmc"}
+	err = Fetch(health.NewStream(), conf, writer.Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(writer.artifacts["api"].Data, published) {
+		t.Fatal("Expected the api to be published without CMC")
+	}
+}
@@ -5,6 +5,14 @@ type exchangeRateType int
 const (
 	exchangeRateTypeFiat exchangeRateType = iota
 	exchangeRateTypeCrypto
+
+	// exchangeRateTypeFX marks FX table entries, which are units of a fiat
+	// currency per EUR rather than rates against BTC
+	exchangeRateTypeFX
+
+	// exchangeRateTypeCross marks market quotes between two symbols other than
+	// BTC, keyed by BASE/QUOTE, which rates can be derived through
+	exchangeRateTypeCross
 )
 
 func (t exchangeRateType) String() string {
@@ -13,6 +21,10 @@ func (t exchangeRateType) String() string {
 		return "fiat"
 	case exchangeRateTypeCrypto:
 		return "crypto"
+	case exchangeRateTypeFX:
+		return "fx"
+	case exchangeRateTypeCross:
+		return "cross"
 	}
 	return ""
 }
@@ -0,0 +1,291 @@
+package ticker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/gocraft/health"
+)
+
+// Validation rule types
+const (
+	// RulePositive requires ask, bid and last to be positive numbers
+	RulePositive = "positive"
+
+	// RuleAskGTEBid requires the ask to be greater than or equal to the bid
+	RuleAskGTEBid = "ask_gte_bid"
+
+	// RuleBand requires the last price of each symbol to be within Min and Max
+	RuleBand = "band"
+
+	// RuleMaxChange limits the relative change of the last price since the
+	// last published snapshot
+	RuleMaxChange = "max_change"
+
+	// RuleMarket requires a market data Field to be within Min and Max.
+	// Symbols without the field, like fiat currencies, pass.
+	RuleMarket = "market"
+)
+
+// Validation rule actions
+const (
+	// RuleActionFail fails the run when a symbol violates the rule
+	RuleActionFail = "fail"
+
+	// RuleActionDrop removes symbols that violate the rule from the output
+	RuleActionDrop = "drop"
+)
+
+// ValidationRule is a check applied to the merged rates before publishing
+type ValidationRule struct {
+	Type   string `json:"type"`
+	Action string `json:"action"`
+
+	// Symbols limits the rule to the given symbols; all symbols are checked
+	// when it is empty
+	Symbols []string `json:"symbols,omitempty"`
+
+	// Min and Max bound the last price for band rules and the Field for
+	// market rules. A Max of 0 means no upper bound, and for market rules a
+	// Min of 0 means no lower bound.
+	Min float64 `json:"min,omitempty"`
+	Max float64 `json:"max,omitempty"`
+
+	// Field is the market data checked by market rules, e.g. volume_24h_usd
+	Field string `json:"field,omitempty"`
+
+	// MaxChange is the largest allowed relative change for max_change rules,
+	// e.g. 0.5 for 50%
+	MaxChange float64 `json:"max_change,omitempty"`
+}
+
+// DefaultValidationRules returns the rules used when none are configured
+func DefaultValidationRules() []ValidationRule {
+	return []ValidationRule{{Type: RulePositive, Action: RuleActionDrop}}
+}
+
+// validate checks that the rule is well formed
+func (r ValidationRule) validate() error {
+	if r.Action != RuleActionFail && r.Action != RuleActionDrop {
+		return fmt.Errorf("%s rule action must be %s or %s, got %q", r.Type, RuleActionFail, RuleActionDrop, r.Action)
+	}
+
+	switch r.Type {
+	case RulePositive, RuleAskGTEBid:
+	case RuleBand:
+		if len(r.Symbols) == 0 {
+			return fmt.Errorf("band rule requires symbols")
+		}
+		if r.Max != 0 && r.Max < r.Min {
+			return fmt.Errorf("band rule max %v is less than min %v", r.Max, r.Min)
+		}
+	case RuleMaxChange:
+		if r.MaxChange <= 0 {
+			return fmt.Errorf("max_change rule requires a positive max_change")
+		}
+	case RuleMarket:
+		if err := validateMarketField(r.Field); err != nil {
+			return fmt.Errorf("market rule: %s", err)
+		}
+		if r.Min == 0 && r.Max == 0 {
+			return fmt.Errorf("market rule requires a min or max")
+		}
+		if r.Max != 0 && r.Max < r.Min {
+			return fmt.Errorf("market rule max %v is less than min %v", r.Max, r.Min)
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", r.Type)
+	}
+
+	return nil
+}
+
+func (r ValidationRule) appliesTo(symbol string) bool {
+	if len(r.Symbols) == 0 {
+		return true
+	}
+	for _, s := range r.Symbols {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns a description of how the rate violates the rule, or an empty
+// string if it doesn't. The previous rate is only used by max_change rules.
+func (r ValidationRule) check(rate exchangeRate, previous exchangeRate, hasPrevious bool) string {
+	switch r.Type {
+	case RulePositive:
+		for i, value := range []json.Number{rate.Ask, rate.Bid, rate.Last} {
+			f, err := value.Float64()
+			if err != nil || f <= 0 || math.IsInf(f, 0) {
+				return fmt.Sprintf("%s %q is not a positive number", []string{"ask", "bid", "last"}[i], value)
+			}
+		}
+
+	case RuleAskGTEBid:
+		ask, askErr := rate.Ask.Float64()
+		bid, bidErr := rate.Bid.Float64()
+		if askErr == nil && bidErr == nil && ask < bid {
+			return fmt.Sprintf("ask %s is less than bid %s", rate.Ask, rate.Bid)
+		}
+
+	case RuleBand:
+		last, err := rate.Last.Float64()
+		if err != nil {
+			return fmt.Sprintf("last %q is not a number", rate.Last)
+		}
+		if last < r.Min {
+			return fmt.Sprintf("last %s is below %v", rate.Last, r.Min)
+		}
+		if r.Max != 0 && last > r.Max {
+			return fmt.Sprintf("last %s is above %v", rate.Last, r.Max)
+		}
+
+	case RuleMaxChange:
+		if !hasPrevious {
+			return ""
+		}
+		last, err := rate.Last.Float64()
+		prevLast, prevErr := previous.Last.Float64()
+		if err != nil || prevErr != nil || prevLast == 0 {
+			return ""
+		}
+		if change := math.Abs(last-prevLast) / prevLast; change > r.MaxChange {
+			return fmt.Sprintf("last changed %.2f%% from %s to %s", change*100, previous.Last, rate.Last)
+		}
+
+	case RuleMarket:
+		value, ok := rate.Market.field(r.Field)
+		if !ok {
+			return ""
+		}
+		if r.Min != 0 && value < r.Min {
+			return fmt.Sprintf("%s %v is below %v", r.Field, value, r.Min)
+		}
+		if r.Max != 0 && value > r.Max {
+			return fmt.Sprintf("%s %v is above %v", r.Field, value, r.Max)
+		}
+	}
+
+	return ""
+}
+
+// ValidationReport describes what the overrides, market floors and validation
+// rules did to a set of rates
+type ValidationReport struct {
+	// Overridden maps symbols changed by overrides to the action and reason
+	Overridden map[string]string `json:"overridden,omitempty"`
+
+	// Filtered maps symbols removed by the market floors to the reason
+	Filtered map[string]string `json:"filtered,omitempty"`
+
+	// Dropped maps symbols removed from the output to the reason
+	Dropped map[string]string `json:"dropped"`
+
+	// Failures are the violations of rules with the fail action
+	Failures []string `json:"failures"`
+}
+
+// applyValidationRules checks the rates against the rules, removing symbols
+// that violate drop rules. It returns an error if any fail rule is violated.
+// The previous rates are the last published snapshot and may be nil. Rates set
+// by overrides aren't checked.
+func applyValidationRules(job *health.Job, rules []ValidationRule, rates exchangeRates, previous exchangeRates) (ValidationReport, error) {
+	report := ValidationReport{Dropped: map[string]string{}}
+
+	symbols := make([]string, 0, len(rates))
+	for symbol := range rates {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, rule := range rules {
+		for _, symbol := range symbols {
+			rate, ok := rates[symbol]
+			if !ok || rate.Override != nil || !rule.appliesTo(symbol) {
+				continue
+			}
+
+			previousRate, hasPrevious := previous[symbol]
+			problem := rule.check(rate, previousRate, hasPrevious)
+			if problem == "" {
+				continue
+			}
+
+			kvs := health.Kvs{"rule": rule.Type, "symbol": symbol, "problem": problem}
+			if rule.Action == RuleActionDrop {
+				delete(rates, symbol)
+				report.Dropped[symbol] = fmt.Sprintf("%s: %s", rule.Type, problem)
+				job.EventKv("validate.drop", kvs)
+				continue
+			}
+
+			report.Failures = append(report.Failures, fmt.Sprintf("%s %s: %s", symbol, rule.Type, problem))
+			job.EventKv("validate.fail", kvs)
+		}
+	}
+
+	if len(report.Failures) > 0 {
+		return report, errValidationFailed(strings.Join(report.Failures, "; "))
+	}
+	return report, nil
+}
+
+// hasRuleType checks if any of the rules is of the given type
+func hasRuleType(rules []ValidationRule, ruleType string) bool {
+	for _, rule := range rules {
+		if rule.Type == ruleType {
+			return true
+		}
+	}
+	return false
+}
+
+type errValidationFailed string
+
+func (e errValidationFailed) Error() string {
+	return "Validation failed: " + string(e)
+}
+
+// ValidateDocument checks an api document against the validation rules and the
+// required symbols. Symbols that drop rules would remove are reported in the
+// Dropped field of the report.
+func ValidateDocument(stream *health.Stream, conf Config, data []byte) (ValidationReport, error) {
+	job := stream.NewJob("validate")
+
+	rates := exchangeRates{}
+	err := json.Unmarshal(data, &rates)
+	if err != nil {
+		job.EventErr("unmarshal", err)
+		job.Complete(health.Error)
+		return ValidationReport{}, err
+	}
+
+	var previousRates exchangeRates
+	if hasRuleType(conf.ValidationRules, RuleMaxChange) {
+		previousRates, err = loadPublishedRates(conf)
+		if err != nil {
+			job.EventErr("load_published_rates", err)
+			job.Complete(health.Error)
+			return ValidationReport{}, err
+		}
+	}
+
+	report, err := applyValidationRules(job, conf.ValidationRules, rates, previousRates)
+	if err == nil {
+		err = validateRates(rates)
+	}
+	if err != nil {
+		job.EventErr("validate_rates", err)
+		job.Complete(health.ValidationError)
+		return report, err
+	}
+
+	job.Complete(health.Success)
+	return report, nil
+}
@@ -0,0 +1,107 @@
+package ticker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gocraft/health"
+)
+
+func TestApplyValidationRules(t *testing.T) {
+	job := health.NewStream().NewJob("fetch")
+	newRates := func() exchangeRates {
+		return exchangeRates{
+			"BTC":  {Ask: "1", Bid: "1", Last: "1"},
+			"USD":  {Ask: "6500", Bid: "6400", Last: "6450"},
+			"EUR":  {Ask: "5600", Bid: "5700", Last: "5650"},
+			"ZERO": {Ask: "0", Bid: "0", Last: "0"},
+			"NULL": {Ask: "", Bid: "", Last: ""},
+		}
+	}
+	previous := exchangeRates{
+		"USD": {Ask: "65000", Bid: "64000", Last: "64500"},
+		"EUR": {Ask: "5500", Bid: "5600", Last: "5550"},
+	}
+
+	rates := newRates()
+	report, err := applyValidationRules(job, []ValidationRule{
+		{Type: RulePositive, Action: RuleActionDrop},
+		{Type: RuleAskGTEBid, Action: RuleActionDrop},
+		{Type: RuleBand, Action: RuleActionDrop, Symbols: []string{"BTC"}, Min: 1, Max: 1},
+	}, rates, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 {
+		t.Fatal("Incorrect rates after dropping:", rates)
+	}
+	expectedDropped := []string{"EUR", "NULL", "ZERO"}
+	for _, symbol := range expectedDropped {
+		if _, ok := report.Dropped[symbol]; !ok {
+			t.Fatal("Expected symbol to be dropped:", symbol, report.Dropped)
+		}
+	}
+
+	// Fail rules return an error describing every violation
+	rates = newRates()
+	report, err = applyValidationRules(job, []ValidationRule{
+		{Type: RulePositive, Action: RuleActionDrop},
+		{Type: RuleMaxChange, Action: RuleActionFail, MaxChange: 0.5},
+		{Type: RuleBand, Action: RuleActionFail, Symbols: []string{"EUR"}, Min: 6000},
+	}, rates, previous)
+	if _, ok := err.(errValidationFailed); !ok {
+		t.Fatal("Expected validation error, got:", err)
+	}
+	expectedFailures := []string{
+		"USD max_change: last changed 90.00% from 64500 to 6450",
+		"EUR band: last 5650 is below 6000",
+	}
+	if !reflect.DeepEqual(report.Failures, expectedFailures) {
+		t.Fatal("Incorrect failures:", report.Failures)
+	}
+}
+
+func TestValidationRuleValidate(t *testing.T) {
+	for _, rule := range []ValidationRule{
+		{Type: RulePositive, Action: "warn"},
+		{Type: "unknown", Action: RuleActionFail},
+		{Type: RuleBand, Action: RuleActionFail},
+		{Type: RuleBand, Action: RuleActionFail, Symbols: []string{"USD"}, Min: 2, Max: 1},
+		{Type: RuleMaxChange, Action: RuleActionFail},
+	} {
+		if rule.validate() == nil {
+			t.Fatal("Expected rule to be invalid:", rule)
+		}
+	}
+
+	for _, rule := range DefaultValidationRules() {
+		if err := rule.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAskGTEBidRuleWithBTCAVGCrypto(t *testing.T) {
+	// BTCAVG quotes coins in BTC, so their BTC asks are above their bids
+	rates := exchangeRates{}
+	err := formatBTCAVGCryptoOutput(rates, exchangeRates{
+		"FOOBTC": {Ask: "0.051", Bid: "0.049", Last: "0.05"},
+		"BARBTC": {Ask: "0.0031", Bid: "0.0029", Last: "0.003"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 {
+		t.Fatal("Incorrect rates:", rates)
+	}
+
+	report, err := applyValidationRules(health.NewStream().NewJob("fetch"), []ValidationRule{
+		{Type: RuleAskGTEBid, Action: RuleActionFail},
+	}, rates, nil)
+	if err != nil {
+		t.Fatal("Expected BTCAVG crypto rates to pass ask_gte_bid:", err, rates)
+	}
+	if len(report.Dropped) != 0 {
+		t.Fatal("Unexpected dropped rates:", report.Dropped)
+	}
+}
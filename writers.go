@@ -3,7 +3,11 @@ package ticker
 import (
 	"bytes"
 	"io/ioutil"
+	"net/http"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -12,29 +16,55 @@ import (
 	"github.com/gocraft/health"
 )
 
-// Writer is a callback for data collected from the backend sources
-type Writer func(job *health.Job, data []byte) error
+// Artifact is a named document published by a run
+type Artifact struct {
+	Name string
+	Data []byte
+}
 
-// NewFileSystemWriter creates a Writer to writes to a local filesystem
-func NewFileSystemWriter(outpath string) Writer {
-	return func(job *health.Job, data []byte) error {
-		filePath := path.Join(outpath, "api")
-		writerKvs := health.Kvs{"path": filePath}
-		err := ioutil.WriteFile(filePath, data, 0644)
+// Writer is a callback for the artifacts built from the backend sources
+type Writer func(job *health.Job, artifacts []Artifact) error
+
+// NamedWriter is a Writer with a name its outcome is reported under
+type NamedWriter struct {
+	Name   string
+	Writer Writer
+}
+
+// NewWriters creates the Writers selected by the config: a file system writer
+// when an output path is set and an S3 writer when a bucket is set
+func NewWriters(conf Config) ([]NamedWriter, error) {
+	writers := []NamedWriter{}
+
+	if conf.OutPath != "" {
+		writers = append(writers, NamedWriter{"file_system", NewFileSystemWriter(conf.OutPath)})
+	}
+
+	if conf.AWSS3Bucket != "" {
+		writer, err := NewS3Writer(conf.AWSS3Region, conf.AWSS3Bucket)
 		if err != nil {
-			job.EventErrKv("write.file_system.rates", err, writerKvs)
-			return err
+			return nil, err
 		}
-		job.EventKv("write.file_system.rates", writerKvs)
+		writers = append(writers, NamedWriter{"s3", writer})
+	}
 
-		filePath = path.Join(outpath, "whitelist")
-		writerKvs = health.Kvs{"path": filePath}
-		err = ioutil.WriteFile(filePath, PinnedSymbolsToIDsJSON(), 0644)
-		if err != nil {
-			job.EventErrKv("write.file_system.whitelist", err, writerKvs)
-			return err
+	return writers, nil
+}
+
+// NewFileSystemWriter creates a Writer to writes to a local filesystem
+func NewFileSystemWriter(outpath string) Writer {
+	return func(job *health.Job, artifacts []Artifact) error {
+		defer timeWriter(job, "file_system", time.Now())
+		for _, artifact := range artifacts {
+			filePath := path.Join(outpath, artifact.Name)
+			writerKvs := health.Kvs{"path": filePath}
+			err := ioutil.WriteFile(filePath, artifact.Data, 0644)
+			if err != nil {
+				job.EventErrKv("write.file_system."+artifact.Name, err, writerKvs)
+				return err
+			}
+			job.EventKv("write.file_system."+artifact.Name, writerKvs)
 		}
-		job.EventKv("write.file_system.whitelist", writerKvs)
 		return nil
 	}
 }
@@ -49,30 +79,67 @@ func NewS3Writer(region string, bucket string) (Writer, error) {
 	s3CFG := aws.NewConfig().WithRegion(region).WithCredentials(creds)
 	s3Client := s3.New(session.New(), s3CFG)
 
-	return func(job *health.Job, data []byte) error {
-		_, err := s3Client.PutObject(&s3.PutObjectInput{
-			Key:           aws.String("api"),
-			Bucket:        aws.String(bucket),
-			Body:          bytes.NewReader(data),
-			ContentLength: aws.Int64(int64(len(data))),
-			ContentType:   aws.String("application/json"),
-		})
-		if err != nil {
-			job.EventErr("write.s3.rates", err)
-			return err
-		}
-		_, err = s3Client.PutObject(&s3.PutObjectInput{
-			Key:           aws.String("whitelist"),
-			Bucket:        aws.String(bucket),
-			Body:          bytes.NewReader(PinnedSymbolsToIDsJSON()),
-			ContentLength: aws.Int64(int64(len(PinnedSymbolsToIDsJSON()))),
-			ContentType:   aws.String("application/json"),
-		})
-		if err != nil {
-			job.EventErr("write.s3.whitelist", err)
-			return err
+	return func(job *health.Job, artifacts []Artifact) error {
+		defer timeWriter(job, "s3", time.Now())
+		for _, artifact := range artifacts {
+			_, err := s3Client.PutObject(&s3.PutObjectInput{
+				Key:           aws.String(artifact.Name),
+				Bucket:        aws.String(bucket),
+				Body:          bytes.NewReader(artifact.Data),
+				ContentLength: aws.Int64(int64(len(artifact.Data))),
+				ContentType:   aws.String("application/json"),
+			})
+			if err != nil {
+				job.EventErr("write.s3."+artifact.Name, err)
+				return err
+			}
 		}
 		job.Event("write.s3")
 		return nil
 	}, nil
 }
+
+// timeWriter reports how long the named writer took since start
+func timeWriter(job *health.Job, writer string, start time.Time) {
+	job.TimingKv("write", time.Since(start).Nanoseconds(), health.Kvs{"writer": writer})
+}
+
+// MemoryWriter keeps the most recently written artifacts in memory and serves
+// them over HTTP by name
+type MemoryWriter struct {
+	mu        sync.RWMutex
+	artifacts map[string]Artifact
+}
+
+// NewMemoryWriter creates an empty MemoryWriter
+func NewMemoryWriter() *MemoryWriter {
+	return &MemoryWriter{artifacts: map[string]Artifact{}}
+}
+
+// Write stores the artifacts, replacing any with the same names. It satisfies
+// the Writer type.
+func (w *MemoryWriter) Write(job *health.Job, artifacts []Artifact) error {
+	defer timeWriter(job, "memory", time.Now())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, artifact := range artifacts {
+		w.artifacts[artifact.Name] = artifact
+	}
+	job.Event("write.memory")
+	return nil
+}
+
+// ServeHTTP serves the artifact named by the request path
+func (w *MemoryWriter) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.RLock()
+	artifact, ok := w.artifacts[strings.TrimPrefix(r.URL.Path, "/")]
+	w.mu.RUnlock()
+
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(artifact.Data)
+}
@@ -0,0 +1,41 @@
+package ticker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocraft/health"
+)
+
+func TestMemoryWriter(t *testing.T) {
+	writer := NewMemoryWriter()
+	err := writer.Write(health.NewStream().NewJob("fetch"), []Artifact{
+		{Name: "api", Data: []byte(`{"BTC":{}}`)},
+		{Name: "whitelist", Data: []byte(`{}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(writer)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatal("Incorrect api response:", resp.StatusCode, resp.Header)
+	}
+
+	resp, err = http.Get(server.URL + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal("Expected not found, got:", resp.StatusCode)
+	}
+}